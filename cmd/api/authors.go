@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Jcastel2014/test3/internal/data"
+	"github.com/Jcastel2014/test3/internal/validator"
+)
+
+func (a *appDependencies) getAllAuthors(w http.ResponseWriter, r *http.Request) {
+	var queryParametersData struct {
+		Name string
+		data.Filters
+	}
+
+	queryParameters := r.URL.Query()
+
+	queryParametersData.Name = a.getSingleQueryParameters(queryParameters, "name", "")
+	queryParametersData.Filters.Sort = a.getSingleQueryParameters(queryParameters, "sort", "id")
+
+	queryParametersData.Filters.SortSafeList = []string{"id", "name", "-id", "-name"}
+	queryParametersData.Filters.Cursor = a.getSingleQueryParameters(queryParameters, "cursor", "")
+
+	v := validator.New()
+
+	queryParametersData.Filters.Page = a.getSingleIntegerParameters(queryParameters, "page", 1, v)
+	queryParametersData.Filters.PageSize = a.getSingleIntegerParameters(queryParameters, "page_size", 10, v)
+
+	data.ValidateFilters(v, queryParametersData.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	authors, metadata, err := a.bookclub.GetAllAuthors(r.Context(), queryParametersData.Name, queryParametersData.Filters)
+
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"authors":   authors,
+		"@metadata": metadata,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, a.paginationLinks(r, metadata))
+
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+	}
+}
+
+func (a *appDependencies) getAuthor(w http.ResponseWriter, r *http.Request) {
+
+	id, err := a.readIDParam(r)
+
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	author, err := a.bookclub.GetAuthor(r.Context(), id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+
+		return
+	}
+
+	data := envelope{
+		"author": author,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+}
+
+func (a *appDependencies) postAuthor(w http.ResponseWriter, r *http.Request) {
+
+	var incomingData struct {
+		Name string `json:"name"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	author := &data.Author{
+		Name: strings.TrimSpace(incomingData.Name),
+	}
+
+	v := validator.New()
+	data.ValidateAuthor(v, author)
+
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.bookclub.InsertAuthor(r.Context(), author)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateAuthor):
+			v.AddError("name", "an author with this name already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/authors/%d", author.ID))
+
+	data := envelope{
+		"author": author,
+	}
+
+	err = a.writeJSON(w, http.StatusCreated, data, headers)
+
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+}
+
+func (a *appDependencies) putAuthor(w http.ResponseWriter, r *http.Request) {
+
+	id, err := a.readIDParam(r)
+
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var incomingData struct {
+		Name string `json:"name"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	author := &data.Author{
+		ID:   id,
+		Name: strings.TrimSpace(incomingData.Name),
+	}
+
+	v := validator.New()
+
+	data.ValidateAuthor(v, author)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.bookclub.UpdateAuthor(r.Context(), author)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateAuthor):
+			v.AddError("name", "an author with this name already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"author": author,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+}
+
+func (a *appDependencies) deleteAuthor(w http.ResponseWriter, r *http.Request) {
+
+	id, err := a.readIDParam(r)
+
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	err = a.bookclub.DeleteAuthor(r.Context(), id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAuthorHasBooks):
+			a.authorHasBooksResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+
+		return
+	}
+
+	data := envelope{
+		"message": "author successfully deleted",
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+	}
+
+}
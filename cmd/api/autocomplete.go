@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Jcastel2014/test3/internal/data"
+	"github.com/Jcastel2014/test3/internal/validator"
+)
+
+func (a *appDependencies) autocomplete(w http.ResponseWriter, r *http.Request) {
+	queryParameters := r.URL.Query()
+
+	q := strings.TrimSpace(a.getSingleQueryParameters(queryParameters, "q", ""))
+
+	v := validator.New()
+
+	limit := a.getSingleIntegerParameters(queryParameters, "limit", 10, v)
+
+	data.ValidateAutocomplete(v, q, limit)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := a.bookclub.Autocomplete(r.Context(), q, limit)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	// the same prefix gets asked for over and over while someone types
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "private, max-age=60")
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, headers)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+	}
+}
@@ -1,25 +1,26 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 
 	"github.com/Jcastel2014/test3/internal/data"
 	"github.com/Jcastel2014/test3/internal/validator"
+	"github.com/julienschmidt/httprouter"
 )
 
 func (a *appDependencies) postBook(w http.ResponseWriter, r *http.Request) {
 
 	var incomingData struct {
-		Title            string    `json:"title"`
-		ISBN             string    `json:"isbn"`
-		Author           string    `json:"author"`
-		Genre            string    `json:"genre"`
-		Description      string    `json:"description"`
-		Publication_Date time.Time `json:"created_at"`
+		Title            string        `json:"title"`
+		ISBN             string        `json:"isbn"`
+		Authors          []data.Author `json:"authors"`
+		Genre            string        `json:"genre"`
+		Description      string        `json:"description"`
+		Publication_Date time.Time     `json:"created_at"`
 	}
 
 	err := a.readJSON(w, r, &incomingData)
@@ -29,8 +30,8 @@ func (a *appDependencies) postBook(w http.ResponseWriter, r *http.Request) {
 	}
 	book := &data.Book{
 		Title:            incomingData.Title,
-		ISBN:             incomingData.ISBN,
-		Author:           incomingData.Author,
+		ISBN:             data.NormalizeISBN(incomingData.ISBN),
+		Authors:          data.NormalizeAuthors(incomingData.Authors),
 		Genre:            incomingData.Genre,
 		Description:      incomingData.Description,
 		Publication_Date: incomingData.Publication_Date,
@@ -44,10 +45,15 @@ func (a *appDependencies) postBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = a.bookclub.InsertBook(book)
+	err = a.bookclub.InsertBook(r.Context(), book)
 
 	if err != nil {
-		a.serverErrResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateISBN):
+			a.conflictingISBN(w, r, book.ISBN)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
 		return
 	}
 
@@ -78,7 +84,7 @@ func (a *appDependencies) getBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	book, err := a.bookclub.GetBook(id)
+	book, err := a.bookclub.GetBook(r.Context(), id)
 
 	if err != nil {
 		switch {
@@ -95,7 +101,7 @@ func (a *appDependencies) getBook(w http.ResponseWriter, r *http.Request) {
 		"book": book,
 	}
 
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeRecordJSON(w, r, http.StatusOK, data, nil, book.Version, book.Updated_at)
 	if err != nil {
 		a.serverErrResponse(w, r, err)
 		return
@@ -103,16 +109,48 @@ func (a *appDependencies) getBook(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (a *appDependencies) PutBook(w http.ResponseWriter, r *http.Request) {
+// bookInput holds the fields of a book a client can write. PUT takes all
+// of them, PATCH merges into the current values.
+type bookInput struct {
+	Title            string        `json:"title"`
+	ISBN             string        `json:"isbn"`
+	Authors          []data.Author `json:"authors"`
+	Genre            string        `json:"genre"`
+	Description      string        `json:"description"`
+	Publication_Date time.Time     `json:"created_at"`
+}
 
+func newBookInput(book *data.Book) bookInput {
+	return bookInput{
+		Title:            book.Title,
+		ISBN:             book.ISBN,
+		Authors:          book.Authors,
+		Genre:            book.Genre,
+		Description:      book.Description,
+		Publication_Date: book.Publication_Date,
+	}
+}
+
+func (i bookInput) apply(book *data.Book) {
+	book.Title = i.Title
+	book.ISBN = data.NormalizeISBN(i.ISBN)
+	book.Authors = data.NormalizeAuthors(i.Authors)
+	book.Genre = i.Genre
+	book.Description = i.Description
+	book.Publication_Date = i.Publication_Date
+}
+
+// editableBook loads the book named in the URL and checks If-Match against
+// it. It returns nil once a response has been sent.
+func (a *appDependencies) editableBook(w http.ResponseWriter, r *http.Request) *data.Book {
 	id, err := a.readIDParam(r)
 
 	if err != nil {
 		a.notFoundResponse(w, r)
-		return
+		return nil
 	}
 
-	book, err := a.bookclub.GetBook(id)
+	book, err := a.bookclub.GetBook(r.Context(), id)
 
 	if err != nil {
 		switch {
@@ -122,72 +160,114 @@ func (a *appDependencies) PutBook(w http.ResponseWriter, r *http.Request) {
 			a.serverErrResponse(w, r, err)
 		}
 
-		return
+		return nil
 	}
 
-	var incomingData struct {
-		Title            *string    `json:"title"`
-		ISBN             *string    `json:"isbn"`
-		Author           *string    `json:"author"`
-		Genre            *string    `json:"genre"`
-		Description      *string    `json:"description"`
-		Publication_Date *time.Time `json:"created_at"`
+	if !a.checkIfMatch(w, r, book.Version) {
+		return nil
 	}
 
-	err = a.readJSON(w, r, &incomingData)
+	return book
+}
 
-	if err != nil {
-		a.badRequestResponse(w, r, err)
-		return
-	}
+// saveBook validates and stores an edited book, then reads it back so the
+// body and ETag match what getBook will serve. It returns nil once a
+// response has been sent.
+func (a *appDependencies) saveBook(w http.ResponseWriter, r *http.Request, book *data.Book) *data.Book {
+	v := validator.New()
 
-	if incomingData.Title != nil {
-		book.Title = *incomingData.Title
+	data.ValidateBook(v, book)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return nil
 	}
 
-	if incomingData.ISBN != nil {
-		book.ISBN = *incomingData.ISBN
+	err := a.bookclub.UpdateBook(r.Context(), book, book.ID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateISBN):
+			a.conflictingISBN(w, r, book.ISBN)
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return nil
 	}
 
-	if incomingData.Author != nil {
-		book.Author = *incomingData.Author
+	book, err = a.bookclub.GetBook(r.Context(), book.ID)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return nil
 	}
 
-	if incomingData.Genre != nil {
-		book.Genre = *incomingData.Genre
+	return book
+}
+
+// PutBook replaces every writable field of a book, anything left out of
+// the body is cleared or fails validation
+func (a *appDependencies) PutBook(w http.ResponseWriter, r *http.Request) {
+	book := a.editableBook(w, r)
+	if book == nil {
+		return
 	}
 
-	if incomingData.Description != nil {
-		book.Description = *incomingData.Description
+	var incomingData bookInput
+
+	err := a.readJSON(w, r, &incomingData)
+
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
 	}
 
-	if incomingData.Publication_Date != nil {
-		book.Publication_Date = *incomingData.Publication_Date
+	incomingData.apply(book)
+
+	book = a.saveBook(w, r, book)
+	if book == nil {
+		return
 	}
 
-	log.Println(book.ISBN)
+	data := envelope{
+		"book": book,
+	}
 
-	v := validator.New()
+	err = a.writeRecordJSON(w, r, http.StatusOK, data, nil, book.Version, book.Updated_at)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
 
-	data.ValidateBook(v, book)
-	if !v.IsEmpty() {
-		a.failedValidationResponse(w, r, v.Errors)
+}
 
+// patchBook applies a JSON merge patch to a book
+func (a *appDependencies) patchBook(w http.ResponseWriter, r *http.Request) {
+	book := a.editableBook(w, r)
+	if book == nil {
 		return
 	}
 
-	err = a.bookclub.UpdateBook(book, id)
+	incomingData := newBookInput(book)
+
+	changed, err := a.readMergePatch(w, r, &incomingData)
 
 	if err != nil {
-		a.serverErrResponse(w, r, err)
+		a.patchErrorResponse(w, r, err)
 		return
 	}
 
-	data := envelope{
-		"book": book,
+	// nothing to store, and no reason to bump the version
+	if len(changed) > 0 {
+		incomingData.apply(book)
+
+		book = a.saveBook(w, r, book)
+		if book == nil {
+			return
+		}
 	}
 
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writePatchedJSON(w, "book", book, book.Version, changed, book.Updated_at)
 	if err != nil {
 		a.serverErrResponse(w, r, err)
 		return
@@ -204,7 +284,7 @@ func (a *appDependencies) deleteBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = a.bookclub.DeleteBook(id)
+	cover, err := a.bookclub.DeleteBook(r.Context(), id)
 
 	if err != nil {
 		switch {
@@ -217,6 +297,10 @@ func (a *appDependencies) deleteBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a.background(func() {
+		a.deleteCoverFiles(context.Background(), cover, "")
+	})
+
 	data := envelope{
 		"message": "comment successfully deleted",
 	}
@@ -228,14 +312,66 @@ func (a *appDependencies) deleteBook(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func (a *appDependencies) getBookByISBN(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	isbn := data.NormalizeISBN(params.ByName("sub"))
+	if !data.ValidISBN(isbn) {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	book, err := a.bookclub.GetBookByISBN(r.Context(), isbn)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+
+		return
+	}
+
+	err = a.writeRecordJSON(w, r, http.StatusOK, envelope{"book": book}, nil, book.Version, book.Updated_at)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+	}
+}
+
+// conflictingISBN answers a duplicate ISBN with the id of the book that
+// already has it
+func (a *appDependencies) conflictingISBN(w http.ResponseWriter, r *http.Request, isbn string) {
+	existing, err := a.bookclub.GetBookByISBN(r.Context(), isbn)
+	if err != nil {
+		switch {
+		// the conflicting book was deleted after the insert failed
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.errResponseJSON(w, r, http.StatusConflict, "a book with this isbn already exists")
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
+	}
+
+	a.duplicateISBNResponse(w, r, existing.ID)
+}
+
 func (a *appDependencies) GetAllBooks(w http.ResponseWriter, r *http.Request) {
 	var queryParametersData struct {
 		// Product string
+		data.BookQuery
+		Facets []string
 		data.Filters
 	}
 
 	queryParameters := r.URL.Query()
 	// queryParametersData.Product = a.getSingleQueryParameters(queryParameters, "product", "")
+	queryParametersData.BookQuery.Genre = a.getSingleQueryParameters(queryParameters, "genre", "")
+	queryParametersData.BookQuery.Author = a.getSingleQueryParameters(queryParameters, "author", "")
+	queryParametersData.BookQuery.ISBN = data.NormalizeISBN(a.getSingleQueryParameters(queryParameters, "isbn", ""))
+	queryParametersData.Facets = a.getMultipleQueryParameters(queryParameters, "facets", []string{})
 
 	queryParametersData.Filters.Sort = a.getSingleQueryParameters(queryParameters, "sort", "id")
 	// queryParametersData.Filters.Sort = a.getSingleQueryParameters(queryParameters, "sort", "rating")
@@ -244,19 +380,35 @@ func (a *appDependencies) GetAllBooks(w http.ResponseWriter, r *http.Request) {
 	// queryParametersData.Filters.Sort = a.getSingleQueryParameters(queryParameters, "sort", "updated_at")
 
 	// queryParametersData.Filters.SortSafeList = []string{"id", "rating", "helpful_count", "created_at", "updated_at", "-id", "-rating", "-helpful_count", "-created_at", "-updated_at"}
-	queryParametersData.Filters.SortSafeList = []string{"id", "-id"}
+	queryParametersData.Filters.SortSafeList = []string{"id", "title", "publication_date", "average_rating", "-id", "-title", "-publication_date", "-average_rating"}
+	queryParametersData.Filters.Cursor = a.getSingleQueryParameters(queryParameters, "cursor", "")
 
 	v := validator.New()
 
+	queryParametersData.BookQuery.PublishedAfter = a.getSingleDateParameters(queryParameters, "published_after", v)
+	queryParametersData.BookQuery.PublishedBefore = a.getSingleDateParameters(queryParameters, "published_before", v)
+	queryParametersData.BookQuery.MinRating = a.getSingleFloatParameters(queryParameters, "min_rating", v)
+	queryParametersData.BookQuery.MaxRating = a.getSingleFloatParameters(queryParameters, "max_rating", v)
+
 	queryParametersData.Filters.Page = a.getSingleIntegerParameters(queryParameters, "page", 1, v)
 	queryParametersData.Filters.PageSize = a.getSingleIntegerParameters(queryParameters, "page_size", 10, v)
 
+	format := a.exportFormat(r, v)
+
+	data.ValidateBookQuery(v, queryParametersData.BookQuery)
+	data.ValidateBookFacets(v, queryParametersData.Facets)
 	data.ValidateFilters(v, queryParametersData.Filters)
 	if !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	// exports ignore pagination and send every matching book
+	if format != "" {
+		a.exportBooks(w, r, format, queryParametersData.BookQuery)
+		return
+	}
+
 	// product_id, err := toInt(queryParametersData.Product)
 
 	// if err != nil {
@@ -264,7 +416,7 @@ func (a *appDependencies) GetAllBooks(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	review, err := a.bookclub.GetAllBooks(queryParametersData.Filters)
+	review, metadata, err := a.bookclub.GetAllBooks(r.Context(), queryParametersData.BookQuery, queryParametersData.Filters)
 
 	if err != nil {
 		a.serverErrResponse(w, r, err)
@@ -272,10 +424,25 @@ func (a *appDependencies) GetAllBooks(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := envelope{
-		"review": review,
+		"review":    review,
+		"@metadata": metadata,
 	}
 
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if len(queryParametersData.Facets) > 0 {
+		facets, err := a.bookclub.BookFacets(r.Context(), queryParametersData.BookQuery, queryParametersData.Facets)
+		if err != nil {
+			a.serverErrResponse(w, r, err)
+			return
+		}
+		data["facets"] = facets
+	}
+
+	lastModified := time.Time{}
+	for _, book := range review {
+		lastModified = latest(lastModified, book.Updated_at)
+	}
+
+	err = a.writeConditionalJSON(w, r, http.StatusOK, data, a.paginationLinks(r, metadata), lastModified)
 
 	if err != nil {
 		a.serverErrResponse(w, r, err)
@@ -284,26 +451,33 @@ func (a *appDependencies) GetAllBooks(w http.ResponseWriter, r *http.Request) {
 
 func (a *appDependencies) searchBook(w http.ResponseWriter, r *http.Request) {
 	var queryParametersData struct {
-		Title  string
-		Author string
-		Genre  string
+		data.BookSearch
 		data.Filters
 	}
 
 	queryParameters := r.URL.Query()
-	queryParametersData.Title = a.getSingleQueryParameters(queryParameters, "title", "")
-	queryParametersData.Author = a.getSingleQueryParameters(queryParameters, "author", "")
-	queryParametersData.Genre = a.getSingleQueryParameters(queryParameters, "genre", "")
+	queryParametersData.BookSearch.Query = a.getSingleQueryParameters(queryParameters, "q", "")
+	queryParametersData.BookSearch.Title = a.getSingleQueryParameters(queryParameters, "title", "")
+	queryParametersData.BookSearch.Author = a.getSingleQueryParameters(queryParameters, "author", "")
+	queryParametersData.BookSearch.Genre = a.getSingleQueryParameters(queryParameters, "genre", "")
+
+	queryParametersData.Filters.Sort = a.getSingleQueryParameters(queryParameters, "sort", "-rank")
+	queryParametersData.Filters.SortSafeList = []string{"rank", "id", "title", "-rank", "-id", "-title"}
+	queryParametersData.Filters.Cursor = a.getSingleQueryParameters(queryParameters, "cursor", "")
 
 	v := validator.New()
 
+	queryParametersData.Filters.Page = a.getSingleIntegerParameters(queryParameters, "page", 1, v)
+	queryParametersData.Filters.PageSize = a.getSingleIntegerParameters(queryParameters, "page_size", 10, v)
+
+	data.ValidateBookSearch(v, queryParametersData.BookSearch)
 	data.ValidateFilters(v, queryParametersData.Filters)
 	if !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	review, err := a.bookclub.SearchBook(queryParametersData.Title, queryParametersData.Author, queryParametersData.Genre)
+	books, metadata, err := a.bookclub.SearchBooks(r.Context(), queryParametersData.BookSearch, queryParametersData.Filters)
 
 	if err != nil {
 		a.serverErrResponse(w, r, err)
@@ -311,10 +485,11 @@ func (a *appDependencies) searchBook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := envelope{
-		"review": review,
+		"books":     books,
+		"@metadata": metadata,
 	}
 
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeJSON(w, http.StatusOK, data, a.paginationLinks(r, metadata))
 
 	if err != nil {
 		a.serverErrResponse(w, r, err)
@@ -56,3 +56,25 @@ func (a *appDependencies) contextGetUser(r *http.Request) *data.User {
 
 	return user
 }
+
+/*
+The bearer token itself is kept alongside the user so that handlers which
+act on the current session (logging out) know which token to revoke
+*/
+
+const tokenContextKey = contextKey("token")
+
+func (a *appDependencies) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+func (a *appDependencies) contextGetToken(r *http.Request) string {
+	token, ok := r.Context().Value(tokenContextKey).(string)
+
+	if !ok {
+		panic("missing token value in request context")
+	}
+
+	return token
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+
+	"github.com/Jcastel2014/test3/internal/data"
+	"github.com/Jcastel2014/test3/internal/storage"
+	"github.com/Jcastel2014/test3/internal/validator"
+)
+
+const (
+	maxCoverBytes      = 5 << 20
+	maxCoverDimension  = 6000
+	coverThumbnailSize = 200
+)
+
+var coverExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+}
+
+func (a *appDependencies) putBookCover(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	// leave room for the multipart boundaries around the file itself
+	r.Body = http.MaxBytesReader(w, r.Body, maxCoverBytes+64<<10)
+
+	file, _, err := r.FormFile("cover")
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			a.badRequestResponse(w, r, fmt.Errorf("the cover must not be larger than %d bytes", maxCoverBytes))
+		case errors.Is(err, http.ErrMissingFile):
+			a.badRequestResponse(w, r, errors.New("the cover must be sent as the multipart field \"cover\""))
+		default:
+			a.badRequestResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	body, err := io.ReadAll(io.LimitReader(file, maxCoverBytes+1))
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	// the declared Content-Type is whatever the client says, so go by the bytes
+	contentType := http.DetectContentType(body)
+	extension, permitted := coverExtensions[contentType]
+
+	v := validator.New()
+	v.Check(len(body) <= maxCoverBytes, "cover", fmt.Sprintf("must not be larger than %d bytes", maxCoverBytes))
+	v.Check(permitted, "cover", "must be a JPEG or PNG image")
+
+	if v.IsEmpty() {
+		config, _, err := image.DecodeConfig(bytes.NewReader(body))
+		v.Check(err == nil, "cover", "must be a readable image")
+		if err == nil {
+			v.Check(config.Width <= maxCoverDimension && config.Height <= maxCoverDimension, "cover",
+				fmt.Sprintf("must not be more than %d pixels wide or high", maxCoverDimension))
+		}
+	}
+
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	key := fmt.Sprintf("covers/%d/%x%s", id, sha256.Sum256(body), extension)
+
+	err = a.storage.Put(r.Context(), key, bytes.NewReader(body))
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	previous, err := a.bookclub.SetBookCover(r.Context(), id, key)
+	if err != nil {
+		_ = a.storage.Delete(r.Context(), key)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
+	}
+
+	a.background(func() {
+		ctx := context.Background()
+
+		a.makeCoverThumbnail(ctx, id, key, body)
+		a.deleteCoverFiles(ctx, previous, key)
+	})
+
+	book, err := a.bookclub.GetBook(r.Context(), id)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"book": book}, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+	}
+}
+
+func thumbnailKey(key string) string {
+	return key + ".thumbnail.jpg"
+}
+
+// deleteCoverFiles removes a cover that is no longer used and its thumbnail
+// from storage. The thumbnail is also looked for under the key it would
+// have had, it may have been stored without being recorded yet. keep is the
+// cover now in use, the same image uploaded twice keeps its key.
+func (a *appDependencies) deleteCoverFiles(ctx context.Context, cover *data.Cover, keep string) {
+	if cover.Key == "" {
+		return
+	}
+
+	keys := []string{cover.Key, thumbnailKey(cover.Key)}
+	if cover.ThumbnailKey != "" && cover.ThumbnailKey != thumbnailKey(cover.Key) {
+		keys = append(keys, cover.ThumbnailKey)
+	}
+
+	for _, key := range keys {
+		if keep != "" && (key == keep || key == thumbnailKey(keep)) {
+			continue
+		}
+
+		err := a.storage.Delete(ctx, key)
+		if err != nil {
+			a.logger.Error(err.Error(), "key", key)
+		}
+	}
+}
+
+func (a *appDependencies) makeCoverThumbnail(ctx context.Context, id int64, key string, body []byte) {
+	thumbnail, err := storage.Thumbnail(bytes.NewReader(body), coverThumbnailSize)
+	if err != nil {
+		a.logger.Error(err.Error(), "book", id)
+		return
+	}
+
+	err = a.storage.Put(ctx, thumbnailKey(key), bytes.NewReader(thumbnail))
+	if err != nil {
+		a.logger.Error(err.Error(), "book", id)
+		return
+	}
+
+	err = a.bookclub.SetBookThumbnail(ctx, id, key, thumbnailKey(key))
+	if err != nil {
+		// a newer cover arrived while this one was being shrunk
+		if errors.Is(err, data.ErrEditConflict) {
+			_ = a.storage.Delete(ctx, thumbnailKey(key))
+			return
+		}
+		a.logger.Error(err.Error(), "book", id)
+	}
+}
+
+// getBookCover serves the cover, or its thumbnail with size=thumbnail. Cover
+// URLs carry a version so those responses can be cached indefinitely, but
+// only by the client, reading a cover takes a bearer token.
+func (a *appDependencies) getBookCover(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	cover, err := a.bookclub.GetBookCover(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
+	}
+
+	key := cover.Key
+	if r.URL.Query().Get("size") == "thumbnail" {
+		if cover.ThumbnailKey == "" {
+			a.notFoundResponse(w, r)
+			return
+		}
+		key = cover.ThumbnailKey
+	}
+
+	object, err := a.storage.Get(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
+	}
+	defer object.Body.Close()
+
+	if r.URL.Query().Get("v") != "" {
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+	}
+	// keys are content hashes so they make a strong validator
+	w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(key))))
+
+	http.ServeContent(w, r, key, cover.UpdatedAt, object.Body)
+}
@@ -1,8 +1,8 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 )
 
@@ -43,6 +43,19 @@ func (a *appDependencies) badRequestResponse(w http.ResponseWriter, r *http.Requ
 	a.errResponseJSON(w, r, http.StatusBadRequest, err.Error())
 }
 
+func (a *appDependencies) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, err error) {
+	a.errResponseJSON(w, r, http.StatusUnsupportedMediaType, err.Error())
+}
+
+// patchErrorResponse reports a PATCH body that could not be read
+func (a *appDependencies) patchErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errUnsupportedPatch) {
+		a.unsupportedMediaTypeResponse(w, r, err)
+		return
+	}
+	a.badRequestResponse(w, r, err)
+}
+
 func (a *appDependencies) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	a.errResponseJSON(w, r, http.StatusUnprocessableEntity, errors)
 }
@@ -53,15 +66,6 @@ func (a *appDependencies) rateLimitExceededResponse(w http.ResponseWriter, r *ht
 	a.errResponseJSON(w, r, http.StatusTooManyRequests, message)
 }
 
-func (a *appDependencies) hello() {
-	log.Println("hello")
-}
-
-func (a *appDependencies) bookNotFound(w http.ResponseWriter, r *http.Request, err error) {
-
-	a.errResponseJSON(w, r, http.StatusBadRequest, err.Error())
-}
-
 func (a *appDependencies) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 
 	message := "unable to update the record due to an edit conflict, please try again"
@@ -69,6 +73,11 @@ func (a *appDependencies) editConflictResponse(w http.ResponseWriter, r *http.Re
 
 }
 
+func (a *appDependencies) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has changed since you last fetched it, fetch it again and retry"
+	a.errResponseJSON(w, r, http.StatusPreconditionFailed, message)
+}
+
 func (a *appDependencies) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
 	a.errResponseJSON(w, r, http.StatusUnauthorized, message)
@@ -92,3 +101,27 @@ func (a *appDependencies) inactiveAccountResponse(w http.ResponseWriter, r *http
 	message := "your user account must be activated to access this resource"
 	a.errResponseJSON(w, r, http.StatusForbidden, message)
 }
+
+func (a *appDependencies) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	a.errResponseJSON(w, r, http.StatusForbidden, message)
+}
+
+func (a *appDependencies) authorHasBooksResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the author is still credited on one or more books, remove them from those books first"
+	a.errResponseJSON(w, r, http.StatusConflict, message)
+}
+
+func (a *appDependencies) duplicateListBookResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the book is already on this list"
+	a.errResponseJSON(w, r, http.StatusConflict, message)
+}
+
+// duplicateISBNResponse points the client at the book that already has the ISBN
+func (a *appDependencies) duplicateISBNResponse(w http.ResponseWriter, r *http.Request, bookID int64) {
+	message := envelope{
+		"message": "a book with this isbn already exists",
+		"book_id": bookID,
+	}
+	a.errResponseJSON(w, r, http.StatusConflict, message)
+}
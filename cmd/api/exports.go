@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Jcastel2014/test3/internal/data"
+	"github.com/Jcastel2014/test3/internal/validator"
+)
+
+// rows written between flushes so the client sees a steady stream
+const exportFlushEvery = 100
+
+// the server's WriteTimeout is sized for normal responses, an export gets
+// this long instead
+const exportWriteTimeout = 5 * time.Minute
+
+// the Goodreads export columns a book fills in. Genre and Description aren't
+// Goodreads columns, Goodreads ignores them and our own import reads them.
+var bookExportHeader = []string{"Book Id", "Title", "Author", "Additional Authors", "ISBN", "ISBN13", "Average Rating", "Year Published", "Original Publication Year", "Genre", "Description"}
+
+// exportFormat picks csv or ndjson from the format parameter or the Accept
+// header, an empty result means the client wants the normal JSON response
+func (a *appDependencies) exportFormat(r *http.Request, v *validator.Validator) string {
+	format := r.URL.Query().Get("format")
+	if format != "" {
+		v.Check(validator.PermittedValue(format, "json", "csv", "ndjson"), "format", "must be json, csv or ndjson")
+		if format == "json" {
+			return ""
+		}
+		return format
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := mime.ParseMediaType(strings.TrimSpace(accepted))
+		switch mediaType {
+		case "text/csv":
+			return "csv"
+		case "application/x-ndjson", "application/jsonl":
+			return "ndjson"
+		}
+	}
+
+	return ""
+}
+
+// exportWriter streams rows as CSV or NDJSON. Headers go out with the first
+// row so a query that fails straight away can still get a proper error.
+type exportWriter struct {
+	w        http.ResponseWriter
+	format   string
+	filename string
+	header   []string
+	csv      *csv.Writer
+	json     *json.Encoder
+	started  bool
+	rows     int
+}
+
+func newExportWriter(w http.ResponseWriter, format string, filename string, header []string) *exportWriter {
+	return &exportWriter{w: w, format: format, filename: filename, header: header}
+}
+
+func (e *exportWriter) begin() error {
+	e.started = true
+
+	// not every ResponseWriter supports deadlines, those keep the server's
+	_ = http.NewResponseController(e.w).SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+
+	switch e.format {
+	case "csv":
+		e.w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, e.filename))
+		e.w.WriteHeader(http.StatusOK)
+		e.csv = csv.NewWriter(e.w)
+		return e.csv.Write(e.header)
+	default:
+		e.w.Header().Set("Content-Type", "application/x-ndjson")
+		e.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ndjson"`, e.filename))
+		e.w.WriteHeader(http.StatusOK)
+		e.json = json.NewEncoder(e.w)
+		return nil
+	}
+}
+
+// write sends one row, record for CSV and value for NDJSON
+func (e *exportWriter) write(record []string, value any) error {
+	if !e.started {
+		err := e.begin()
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if e.format == "csv" {
+		err = e.csv.Write(record)
+	} else {
+		err = e.json.Encode(value)
+	}
+	if err != nil {
+		return err
+	}
+
+	e.rows++
+	if e.rows%exportFlushEvery == 0 {
+		e.flush()
+	}
+
+	return nil
+}
+
+func (e *exportWriter) flush() {
+	if e.csv != nil {
+		e.csv.Flush()
+	}
+	if flusher, ok := e.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// finish ends the export. Once rows have gone out the status can't change,
+// so a failure part way through is only logged and the body cut short.
+func (a *appDependencies) finishExport(w http.ResponseWriter, r *http.Request, e *exportWriter, err error) {
+	if err != nil {
+		if !e.started {
+			a.serverErrResponse(w, r, err)
+			return
+		}
+		a.logError(r, err)
+		e.flush()
+		return
+	}
+
+	if !e.started {
+		err = e.begin()
+		if err != nil {
+			a.logError(r, err)
+			return
+		}
+	}
+
+	e.flush()
+}
+
+func bookExportRecord(book *data.Book) []string {
+	author := ""
+	additional := []string{}
+	for i, a := range book.Authors {
+		if i == 0 {
+			author = a.Name
+			continue
+		}
+		additional = append(additional, a.Name)
+	}
+
+	year := ""
+	if !book.Publication_Date.IsZero() {
+		year = strconv.Itoa(book.Publication_Date.Year())
+	}
+
+	return []string{
+		strconv.FormatInt(book.ID, 10),
+		book.Title,
+		author,
+		strings.Join(additional, ", "),
+		data.ISBN10(book.ISBN),
+		book.ISBN,
+		strconv.FormatFloat(book.Average_rating, 'f', 2, 64),
+		year,
+		year,
+		book.Genre,
+		book.Description,
+	}
+}
+
+// the Goodreads exclusive shelf closest to the status of a list entry
+func exclusiveShelf(status string) string {
+	switch status {
+	case data.EntryFinished:
+		return "read"
+	case data.EntryReading:
+		return "currently-reading"
+	case data.EntryAbandoned:
+		return "did-not-finish"
+	default:
+		return "to-read"
+	}
+}
+
+func (a *appDependencies) exportBooks(w http.ResponseWriter, r *http.Request, format string, bookQuery data.BookQuery) {
+	e := newExportWriter(w, format, "books", bookExportHeader)
+
+	err := a.bookclub.ExportBooks(r.Context(), bookQuery, func(book *data.Book) error {
+		return e.write(bookExportRecord(book), book)
+	})
+
+	a.finishExport(w, r, e, err)
+}
+
+func (a *appDependencies) exportUserLists(w http.ResponseWriter, r *http.Request, format string, id int64) {
+	header := append(append([]string{}, bookExportHeader...), "Bookshelves", "Exclusive Shelf")
+	e := newExportWriter(w, format, fmt.Sprintf("user-%d-lists", id), header)
+
+	err := a.userModel.ExportUserLists(r.Context(), id, a.contextGetUser(r).ID, func(row *data.ListExportRow) error {
+		record := append(bookExportRecord(row.Book), row.ListName, exclusiveShelf(row.Status))
+		return e.write(record, row)
+	})
+
+	a.finishExport(w, r, e, err)
+}
+
+func (a *appDependencies) exportUserReviews(w http.ResponseWriter, r *http.Request, format string, id int64) {
+	header := append(append([]string{}, bookExportHeader...), "My Rating", "My Review", "Date Added")
+	e := newExportWriter(w, format, fmt.Sprintf("user-%d-reviews", id), header)
+
+	err := a.userModel.ExportUserReviews(r.Context(), id, func(row *data.ReviewExportRow) error {
+		record := append(bookExportRecord(row.Book),
+			strconv.FormatFloat(row.Rating, 'f', 2, 64),
+			row.Review,
+			row.Created_at.Format("2006/01/02"),
+		)
+		return e.write(record, row)
+	})
+
+	a.finishExport(w, r, e, err)
+}
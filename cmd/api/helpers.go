@@ -1,15 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net"
 	"net/http"
 	"net/url"
+	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Jcastel2014/test3/internal/data"
 	"github.com/Jcastel2014/test3/internal/validator"
 	"github.com/julienschmidt/httprouter"
 )
@@ -64,6 +72,124 @@ func (a *appDependencies) readJSON(w http.ResponseWriter, r *http.Request, desti
 	return nil
 }
 
+// errUnsupportedPatch is returned by readMergePatch when the body is not a
+// JSON merge patch
+var errUnsupportedPatch = errors.New("the body must be an application/merge-patch+json document")
+
+// readMergePatch applies the RFC 7396 merge patch in the request body to
+// target, which holds the current state of the editable fields. A null
+// removes a member so the field falls back to its zero value. The names of
+// the top level fields whose value actually changed are returned.
+func (a *appDependencies) readMergePatch(w http.ResponseWriter, r *http.Request, target any) ([]string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		return nil, errUnsupportedPatch
+	}
+
+	var patch map[string]any
+	err := a.readJSON(w, r, &patch)
+	if err != nil {
+		return nil, err
+	}
+	if patch == nil {
+		return nil, errors.New("the body must be a JSON object")
+	}
+
+	current, err := json.Marshal(target)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]any
+	err = json.Unmarshal(current, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := mergePatch(document, patch).(map[string]any)
+
+	changed := []string{}
+	for key := range patch {
+		if !reflect.DeepEqual(document[key], merged[key]) {
+			changed = append(changed, key)
+		}
+	}
+	slices.Sort(changed)
+
+	patched, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	// start from zero values so the members the patch removed are cleared
+	value := reflect.ValueOf(target).Elem()
+	value.Set(reflect.Zero(value.Type()))
+
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(target)
+	if err != nil {
+		var unmarshalTypeErr *json.UnmarshalTypeError
+
+		switch {
+		case errors.As(err, &unmarshalTypeErr):
+			return nil, fmt.Errorf("the body contains the incorrect JSON type for field %q", unmarshalTypeErr.Field)
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return nil, fmt.Errorf("body contains unknown key %s", fieldName)
+		default:
+			return nil, err
+		}
+	}
+
+	return changed, nil
+}
+
+// mergePatch is the MergePatch function from RFC 7396. Neither argument is
+// modified.
+func mergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	result := map[string]any{}
+	if targetObject, ok := target.(map[string]any); ok {
+		for key, value := range targetObject {
+			result[key] = value
+		}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(result, key)
+			continue
+		}
+		result[key] = mergePatch(result[key], value)
+	}
+
+	return result
+}
+
+// writePatchedJSON answers a PATCH with the updated record under key and
+// the fields that changed. The ETag is the one a GET of the record sends,
+// so it can be used for the next If-Match.
+func (a *appDependencies) writePatchedJSON(w http.ResponseWriter, key string, record any, version int, changed []string, lastModified time.Time) error {
+	body, err := marshalJSON(envelope{key: record})
+	if err != nil {
+		return err
+	}
+
+	etag := recordETag(version, body)
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+	headers.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+
+	return a.writeJSON(w, http.StatusOK, envelope{key: record, "changed": changed}, headers)
+}
+
 func (a *appDependencies) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	data := envelope{
 		"status": "available",
@@ -80,12 +206,11 @@ func (a *appDependencies) healthCheckHandler(w http.ResponseWriter, r *http.Requ
 }
 
 func (a *appDependencies) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
-	jsResponse, err := json.MarshalIndent(data, "", "\t")
+	jsResponse, err := marshalJSON(data)
 	if err != nil {
 		return err
 	}
 
-	jsResponse = append(jsResponse, '\n')
 	for key, value := range headers {
 		w.Header()[key] = value
 	}
@@ -99,14 +224,195 @@ func (a *appDependencies) writeJSON(w http.ResponseWriter, status int, data enve
 	return nil
 }
 
+// marshalJSON is the exact body writeJSON sends, entity tags are worked out
+// over the same bytes
+func marshalJSON(data envelope) ([]byte, error) {
+	jsResponse, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(jsResponse, '\n'), nil
+}
+
+// contentETag is a strong entity tag, the hash of the response body
+func contentETag(body []byte) string {
+	return fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+}
+
+// recordETag is the strong entity tag of a single versioned record, its
+// version followed by a hash of the body. If-Match only looks at the
+// version, so the record has to have been edited for it to fail, while
+// If-None-Match compares the whole tag and notices a new average rating,
+// cover or author name as well.
+func recordETag(version int, body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`"%d-%x"`, version, sum[:8])
+}
+
+// etagVersion is the record version an entity tag was made from. Both the
+// tags recordETag makes and a bare quoted version are accepted, weak tags
+// never are.
+func etagVersion(tag string) (int, bool) {
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+
+	versionPart, _, _ := strings.Cut(tag[1:len(tag)-1], "-")
+
+	version, err := strconv.Atoi(versionPart)
+	if err != nil {
+		return 0, false
+	}
+
+	return version, true
+}
+
+// writeConditionalJSON is writeJSON plus an ETag and, when lastModified is
+// set, a Last-Modified header. A GET whose If-None-Match or
+// If-Modified-Since shows the client already has this body gets a 304.
+// Listings are tagged with a hash of the body.
+func (a *appDependencies) writeConditionalJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header, lastModified time.Time) error {
+	jsResponse, err := marshalJSON(data)
+	if err != nil {
+		return err
+	}
+
+	return a.writeTaggedJSON(w, r, status, jsResponse, contentETag(jsResponse), headers, lastModified)
+}
+
+// writeRecordJSON is writeConditionalJSON for a single versioned record,
+// the ETag is the one checkIfMatch accepts for that version
+func (a *appDependencies) writeRecordJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header, version int, lastModified time.Time) error {
+	jsResponse, err := marshalJSON(data)
+	if err != nil {
+		return err
+	}
+
+	return a.writeTaggedJSON(w, r, status, jsResponse, recordETag(version, jsResponse), headers, lastModified)
+}
+
+func (a *appDependencies) writeTaggedJSON(w http.ResponseWriter, r *http.Request, status int, jsResponse []byte, etag string, headers http.Header, lastModified time.Time) error {
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if (r.Method == http.MethodGet || r.Method == http.MethodHead) && notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err := w.Write(jsResponse)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// notModified follows RFC 9110, If-None-Match wins when both are sent and
+// compares weakly, If-Modified-Since only has second precision
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch != "" {
+		for _, tag := range strings.Split(ifNoneMatch, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	ifModifiedSince := r.Header.Get("If-Modified-Since")
+	if ifModifiedSince == "" || lastModified.IsZero() {
+		return false
+	}
+
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// latest is the most recent of the given times, for a Last-Modified that
+// covers several records
+func latest(times ...time.Time) time.Time {
+	var result time.Time
+	for _, t := range times {
+		if t.After(result) {
+			result = t
+		}
+	}
+	return result
+}
+
+// paginationLinks builds an RFC 8288 Link header pointing at the first,
+// previous, next and last pages of the listing, keeping every other query
+// parameter the client sent
+func (a *appDependencies) paginationLinks(r *http.Request, metadata data.Metadata) http.Header {
+	headers := make(http.Header)
+
+	//a client following cursors only ever gets told where to go next
+	if r.URL.Query().Get("cursor") != "" {
+		if metadata.NextCursor != "" {
+			query := r.URL.Query()
+			query.Set("cursor", metadata.NextCursor)
+			headers.Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, query.Encode()))
+		}
+		return headers
+	}
+
+	if metadata.TotalRecords == 0 {
+		return headers
+	}
+
+	link := func(page int, rel string) string {
+		query := r.URL.Query()
+		query.Set("page", strconv.Itoa(page))
+		query.Set("page_size", strconv.Itoa(metadata.PageSize))
+
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, query.Encode(), rel)
+	}
+
+	links := []string{link(metadata.FirstPage, "first")}
+
+	if metadata.CurrentPage > metadata.FirstPage {
+		links = append(links, link(metadata.CurrentPage-1, "prev"))
+	}
+
+	if metadata.CurrentPage < metadata.LastPage {
+		links = append(links, link(metadata.CurrentPage+1, "next"))
+	}
+
+	links = append(links, link(metadata.LastPage, "last"))
+
+	headers.Set("Link", strings.Join(links, ", "))
+
+	return headers
+}
+
 func (a *appDependencies) readIDParam(r *http.Request) (int64, error) {
+	return a.readNamedIDParam(r, "id")
+}
+
+// readNamedIDParam reads an id from a route parameter other than :id
+func (a *appDependencies) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 
 	if err != nil || id < 1 {
 
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -146,17 +452,87 @@ func (a *appDependencies) getSingleIntegerParameters(queryParameters url.Values,
 	return intValue
 }
 
-func toInt(s string) (int64, error) {
+func (a *appDependencies) getSingleFloatParameters(queryParameters url.Values, key string, v *validator.Validator) *float64 {
+	result := queryParameters.Get(key)
+	if result == "" {
+		return nil
+	}
 
-	if s == "" {
-		return 0, nil
+	floatValue, err := strconv.ParseFloat(result, 64)
+	if err != nil {
+		v.AddError(key, "must be a number")
+		return nil
 	}
-	n, err := strconv.ParseInt(s, 10, 64)
+
+	return &floatValue
+}
+
+// getSingleDateParameters reads a YYYY-MM-DD date from the query string
+func (a *appDependencies) getSingleDateParameters(queryParameters url.Values, key string, v *validator.Validator) *time.Time {
+	result := queryParameters.Get(key)
+	if result == "" {
+		return nil
+	}
+
+	date, err := time.Parse(time.DateOnly, result)
+	if err != nil {
+		v.AddError(key, "must be a date in the format YYYY-MM-DD")
+		return nil
+	}
+
+	return &date
+}
+
+// checkIfMatch honours an If-Match header against the record's current
+// version, sending 412 and returning false when none of the tags match.
+// Without the header the update goes ahead and the version check in the
+// query still catches a concurrent edit.
+func (a *appDependencies) checkIfMatch(w http.ResponseWriter, r *http.Request, version int) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return true
+	}
+
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+
+		tagVersion, ok := etagVersion(tag)
+		if ok && tagVersion == version {
+			return true
+		}
+	}
+
+	a.preconditionFailedResponse(w, r)
+	return false
+}
+
+// clientIP is the address the request came from, without the port
+func (a *appDependencies) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return 0, err
+		return r.RemoteAddr
 	}
 
-	return n, nil
+	return ip
+}
+
+// dispatch picks a handler by the value of a route parameter. httprouter
+// won't register a static segment where a named parameter already lives,
+// so fixed sub-resources share the pattern and are split out here.
+func (a *appDependencies) dispatch(param string, routes map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		handler, found := routes[params.ByName(param)]
+		if found {
+			handler(w, r)
+			return
+		}
+		next(w, r)
+	}
 }
 
 func (a *appDependencies) background(fn func()) {
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// the examples from appendix A of RFC 7396
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		patch  string
+		want   string
+	}{
+		{"replace member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"null deletes member", `{"a":"b"}`, `{"a":null}`, `{}`},
+		{"null deletes only that member", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"array replaces string", `{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"string replaces array", `{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{"nested merge", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{"array of objects is replaced", `{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{"array replaces array", `["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{"object replaces array", `["a"]`, `{"a":"b"}`, `{"a":"b"}`},
+		{"array replaces object", `{"a":"b"}`, `["c"]`, `["c"]`},
+		{"null replaces object", `{"a":"foo"}`, `null`, `null`},
+		{"string replaces object", `{"a":"foo"}`, `"bar"`, `"bar"`},
+		{"null in the target is kept", `{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{"object patch replaces array target", `[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{"nested null on missing member", `{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := decodeTestJSON(t, tt.target)
+			patch := decodeTestJSON(t, tt.patch)
+			want := decodeTestJSON(t, tt.want)
+
+			got := mergePatch(target, patch)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("mergePatch(%s, %s) = %v, want %s", tt.target, tt.patch, got, tt.want)
+			}
+
+			// the target must come back untouched
+			if !reflect.DeepEqual(target, decodeTestJSON(t, tt.target)) {
+				t.Errorf("mergePatch modified the target %s", tt.target)
+			}
+		})
+	}
+}
+
+func TestReadMergePatch(t *testing.T) {
+	type entry struct {
+		Title  string         `json:"title"`
+		Pages  *int           `json:"pages,omitempty"`
+		Tags   []string       `json:"tags"`
+		Extras map[string]int `json:"extras"`
+	}
+
+	pages := 320
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        entry
+		changed     []string
+		err         string
+	}{
+		{
+			name:        "merge patch",
+			contentType: "application/merge-patch+json",
+			body:        `{"title":"Emma"}`,
+			want:        entry{Title: "Emma", Pages: &pages, Tags: []string{"classic"}, Extras: map[string]int{"a": 1}},
+			changed:     []string{"title"},
+		},
+		{
+			name:        "plain json with charset",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"title":"Emma"}`,
+			want:        entry{Title: "Emma", Pages: &pages, Tags: []string{"classic"}, Extras: map[string]int{"a": 1}},
+			changed:     []string{"title"},
+		},
+		{
+			name:        "null clears a field",
+			contentType: "application/merge-patch+json",
+			body:        `{"pages":null}`,
+			want:        entry{Title: "Dune", Tags: []string{"classic"}, Extras: map[string]int{"a": 1}},
+			changed:     []string{"pages"},
+		},
+		{
+			name:        "arrays are replaced and objects merged",
+			contentType: "application/merge-patch+json",
+			body:        `{"tags":["new"],"extras":{"b":2}}`,
+			want:        entry{Title: "Dune", Pages: &pages, Tags: []string{"new"}, Extras: map[string]int{"a": 1, "b": 2}},
+			changed:     []string{"extras", "tags"},
+		},
+		{
+			name:        "unchanged values are not reported",
+			contentType: "application/merge-patch+json",
+			body:        `{"title":"Dune","pages":320}`,
+			want:        entry{Title: "Dune", Pages: &pages, Tags: []string{"classic"}, Extras: map[string]int{"a": 1}},
+			changed:     []string{},
+		},
+		{
+			name:        "unsupported content type",
+			contentType: "application/json-patch+json",
+			body:        `[{"op":"remove","path":"/title"}]`,
+			err:         errUnsupportedPatch.Error(),
+		},
+		{
+			name:        "body is not an object",
+			contentType: "application/merge-patch+json",
+			body:        `null`,
+			err:         "the body must be a JSON object",
+		},
+		{
+			name:        "unknown field",
+			contentType: "application/merge-patch+json",
+			body:        `{"author":"Frank Herbert"}`,
+			err:         `body contains unknown key "author"`,
+		},
+		{
+			name:        "wrong type",
+			contentType: "application/merge-patch+json",
+			body:        `{"pages":"many"}`,
+			err:         `the body contains the incorrect JSON type for field "pages"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &appDependencies{}
+
+			r := httptest.NewRequest("PATCH", "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+
+			got := entry{Title: "Dune", Pages: &pages, Tags: []string{"classic"}, Extras: map[string]int{"a": 1}}
+
+			changed, err := a.readMergePatch(w, r, &got)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("readMergePatch error = %v, want %q", err, tt.err)
+				}
+				if tt.err == errUnsupportedPatch.Error() && !errors.Is(err, errUnsupportedPatch) {
+					t.Errorf("readMergePatch error is not errUnsupportedPatch")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readMergePatch returned %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readMergePatch target = %+v, want %+v", got, tt.want)
+			}
+
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("readMergePatch changed = %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func decodeTestJSON(t *testing.T, document string) any {
+	t.Helper()
+
+	var value any
+	err := json.Unmarshal([]byte(document), &value)
+	if err != nil {
+		t.Fatalf("bad test JSON %s: %v", document, err)
+	}
+	return value
+}
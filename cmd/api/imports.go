@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Jcastel2014/test3/internal/data"
+	"github.com/Jcastel2014/test3/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// how often a running import saves its progress
+const importProgressEvery = 100
+
+func (a *appDependencies) postBookImport(w http.ResponseWriter, r *http.Request) {
+	queryParameters := r.URL.Query()
+
+	format := a.getSingleQueryParameters(queryParameters, "format", "")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/x-ndjson", "application/jsonl", "application/jsonlines":
+			format = "ndjson"
+		}
+	}
+
+	defaultGenre := a.getSingleQueryParameters(queryParameters, "genre", "")
+
+	v := validator.New()
+	v.Check(validator.PermittedValue(format, "csv", "ndjson"), "format", "must be csv or ndjson, set it with format or the Content-Type header")
+	v.Check(len(defaultGenre) <= 50, "genre", "must not be more than 50 characters long")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	maxBytes := 10 << 20
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(maxBytes)))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			a.badRequestResponse(w, r, fmt.Errorf("the body must not be larger than %d bytes", maxBytesErr.Limit))
+		default:
+			a.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	var books []*data.Book
+
+	switch format {
+	case "csv":
+		books, err = data.ParseBookCSV(bytes.NewReader(body), defaultGenre)
+	default:
+		books, err = data.ParseBookNDJSON(bytes.NewReader(body))
+	}
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	if len(books) == 0 {
+		a.badRequestResponse(w, r, errors.New("the file does not contain any books"))
+		return
+	}
+
+	job := &data.ImportJob{
+		UserID:    a.contextGetUser(r).ID,
+		Format:    format,
+		Status:    data.ImportPending,
+		TotalRows: len(books),
+		Report:    []*data.ImportRow{},
+	}
+
+	err = a.bookclub.InsertImportJob(r.Context(), job)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	// the import works on its own copy and publishes progress through the
+	// store, job is left as it was for the response below
+	running := *job
+	running.Report = []*data.ImportRow{}
+
+	a.background(func() {
+		a.runBookImport(&running, books)
+	})
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/books/import/%d", job.ID))
+
+	err = a.writeJSON(w, http.StatusAccepted, envelope{"import": job}, headers)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+	}
+}
+
+// runBookImport validates and inserts each row, skipping any ISBN seen
+// earlier in the file or already in the catalog
+func (a *appDependencies) runBookImport(job *data.ImportJob, books []*data.Book) {
+	ctx := context.Background()
+
+	// a panic part way through would otherwise leave the job running forever
+	defer func() {
+		if job.FinishedAt == nil {
+			finished := time.Now()
+			job.FinishedAt = &finished
+			job.Status = data.ImportFailed
+
+			err := a.bookclub.UpdateImportJob(ctx, job)
+			if err != nil {
+				a.logger.Error(err.Error())
+			}
+		}
+	}()
+
+	job.Status = data.ImportRunning
+	err := a.bookclub.UpdateImportJob(ctx, job)
+	if err != nil {
+		a.logger.Error(err.Error())
+	}
+
+	seen := map[string]int{}
+
+	for i, book := range books {
+		row := &data.ImportRow{Row: i + 1, ISBN: book.ISBN, Title: book.Title}
+
+		v := validator.New()
+		data.ValidateBook(v, book)
+
+		if !v.IsEmpty() {
+			row.Status = data.RowInvalid
+			row.Errors = v.Errors
+		} else if first, found := seen[book.ISBN]; found {
+			row.Status = data.RowDuplicate
+			row.Errors = map[string]string{"isbn": fmt.Sprintf("same isbn as row %d", first)}
+		} else {
+			seen[book.ISBN] = row.Row
+
+			err := a.bookclub.InsertBook(ctx, book)
+			switch {
+			case err == nil:
+				row.Status = data.RowImported
+				row.BookID = book.ID
+			case errors.Is(err, data.ErrDuplicateISBN):
+				row.Status = data.RowDuplicate
+				row.Errors = map[string]string{"isbn": "a book with this isbn already exists"}
+				existing, err := a.bookclub.GetBookByISBN(ctx, book.ISBN)
+				if err == nil {
+					row.BookID = existing.ID
+				}
+			default:
+				a.logger.Error(err.Error(), "import", job.ID, "row", row.Row)
+				row.Status = data.RowFailed
+				row.Errors = map[string]string{"book": "the book could not be saved"}
+			}
+		}
+
+		switch row.Status {
+		case data.RowImported:
+			job.Imported++
+		case data.RowDuplicate:
+			job.Duplicates++
+		default:
+			job.Failed++
+		}
+
+		job.Report = append(job.Report, row)
+		job.Processed++
+
+		if job.Processed%importProgressEvery == 0 {
+			err := a.bookclub.UpdateImportJob(ctx, job)
+			if err != nil {
+				a.logger.Error(err.Error())
+			}
+		}
+	}
+
+	finished := time.Now()
+	job.FinishedAt = &finished
+	job.Status = data.ImportCompleted
+
+	err = a.bookclub.UpdateImportJob(ctx, job)
+	if err != nil {
+		a.logger.Error(err.Error())
+	}
+}
+
+func (a *appDependencies) getBookImport(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.ParseInt(params.ByName("sub"), 10, 64)
+	if err != nil || id < 1 {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	job, err := a.bookclub.GetImportJob(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
+	}
+
+	// the report holds every row that was sent, only the user who sent it
+	// and admins get to see it. Anyone else is told it doesn't exist.
+	user := a.contextGetUser(r)
+	if job.UserID != user.ID {
+		permissions, err := a.permissionModel.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			a.serverErrResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(data.PermissionAdmin) {
+			a.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	err = a.writeJSON(w, http.StatusOK, envelope{"import": job}, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+	}
+}
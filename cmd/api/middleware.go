@@ -120,7 +120,7 @@ func (a *appDependencies) authenticate(next http.Handler) http.Handler {
 		}
 
 		//get the user info relatedw with this authentication token
-		user, err := a.userModel.GetForToken(data.ScopeAuthentication, token)
+		user, err := a.userModel.GetForToken(r.Context(), data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -130,8 +130,14 @@ func (a *appDependencies) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+		//remember when and from where this session was last used
+		err = a.tokenModel.Touch(r.Context(), token, r.UserAgent(), a.clientIP(r))
+		if err != nil {
+			a.logError(r, err)
+		}
 		//add the retrieved user info to the context
 		r = a.contextSetUser(r, user)
+		r = a.contextSetToken(r, token)
 		//call the next handler in the chair
 		next.ServeHTTP(w, r)
 	})
@@ -168,3 +174,95 @@ func (a *appDependencies) requireActivatedUser(next http.HandlerFunc) http.Handl
 	// actually authenticated.
 	return a.requireAuthenticatedUser(fn)
 }
+
+// check that the authenticated user created the reading list in the URL
+func (a *appDependencies) requireListOwner(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.readIDParam(r)
+		if err != nil {
+			a.notFoundResponse(w, r)
+			return
+		}
+
+		if !a.checkListOwner(w, r, id) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+
+	return a.requireActivatedUser(fn)
+}
+
+// check that the authenticated user wrote the review in the URL
+func (a *appDependencies) requireReviewOwner(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.readIDParam(r)
+		if err != nil {
+			a.notFoundResponse(w, r)
+			return
+		}
+
+		owner, err := a.bookclub.GetReviewOwner(r.Context(), id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.notFoundResponse(w, r)
+			default:
+				a.serverErrResponse(w, r, err)
+			}
+			return
+		}
+
+		if owner != a.contextGetUser(r).ID {
+			a.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+
+	return a.requireActivatedUser(fn)
+}
+
+// checkListOwner writes the error response and returns false when the
+// authenticated user doesn't own the list. Handlers that get the list id
+// from the body rather than the URL call it directly.
+func (a *appDependencies) checkListOwner(w http.ResponseWriter, r *http.Request, listID int64) bool {
+	owner, err := a.bookclub.GetListOwner(r.Context(), listID, a.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return false
+	}
+
+	if owner != a.contextGetUser(r).ID {
+		a.notPermittedResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// check that the activated user has been granted the permission code
+func (a *appDependencies) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := a.contextGetUser(r)
+
+		permissions, err := a.permissionModel.GetAllForUser(r.Context(), user.ID)
+		if err != nil {
+			a.serverErrResponse(w, r, err)
+			return
+		}
+
+		if !permissions.Include(code) {
+			a.notPermittedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+
+	return a.requireActivatedUser(fn)
+}
@@ -3,11 +3,13 @@ package main
 import (
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"slices"
+	"time"
 
 	"github.com/Jcastel2014/test3/internal/data"
 	"github.com/Jcastel2014/test3/internal/validator"
+	"github.com/julienschmidt/httprouter"
 )
 
 func (a *appDependencies) postReadingList(w http.ResponseWriter, r *http.Request) {
@@ -15,7 +17,8 @@ func (a *appDependencies) postReadingList(w http.ResponseWriter, r *http.Request
 	var incomingData struct {
 		Name        string `json:"name"`
 		Description string `json:"description"`
-		Created_by  int64  `json:"created_by"`
+		Status      string `json:"status"`
+		Visibility  string `json:"visibility"`
 	}
 
 	err := a.readJSON(w, r, &incomingData)
@@ -26,18 +29,36 @@ func (a *appDependencies) postReadingList(w http.ResponseWriter, r *http.Request
 	readList := &data.ReadListInt{
 		Name:        incomingData.Name,
 		Description: incomingData.Description,
-		Created_by:  incomingData.Created_by,
+		Created_by:  a.contextGetUser(r).ID,
+		Status:      incomingData.Status,
+		Visibility:  incomingData.Visibility,
+	}
+
+	if readList.Status == "" {
+		readList.Status = data.DefaultListStatus
+	}
+
+	if readList.Visibility == "" {
+		readList.Visibility = data.ListPublic
 	}
 
 	v := validator.New()
 	data.ValidateListInt(v, readList)
 
+	status, err := a.lookupStatus(r.Context(), v, readList.Status)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
 	if !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = a.bookclub.InsertList(readList)
+	readList.Status = status.Name
+
+	err = a.bookclub.InsertList(r.Context(), readList, status.ID)
 
 	if err != nil {
 		a.serverErrResponse(w, r, err)
@@ -45,7 +66,7 @@ func (a *appDependencies) postReadingList(w http.ResponseWriter, r *http.Request
 	}
 
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/list/%d", readList.ID))
+	headers.Set("Location", fmt.Sprintf("/api/v1/lists/%d", readList.ID))
 
 	data := envelope{
 		"readList": readList,
@@ -57,9 +78,6 @@ func (a *appDependencies) postReadingList(w http.ResponseWriter, r *http.Request
 		a.serverErrResponse(w, r, err)
 		return
 	}
-
-	fmt.Fprintf(w, "%+v\n", incomingData)
-
 }
 
 func (a *appDependencies) getAllLists(w http.ResponseWriter, r *http.Request) {
@@ -73,6 +91,7 @@ func (a *appDependencies) getAllLists(w http.ResponseWriter, r *http.Request) {
 	queryParametersData.Filters.Sort = a.getSingleQueryParameters(queryParameters, "sort", "id")
 
 	queryParametersData.Filters.SortSafeList = []string{"id", "-id"}
+	queryParametersData.Filters.Cursor = a.getSingleQueryParameters(queryParameters, "cursor", "")
 
 	v := validator.New()
 
@@ -85,7 +104,7 @@ func (a *appDependencies) getAllLists(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	readList, err := a.bookclub.GetAllLists(queryParametersData.Filters)
+	readList, metadata, err := a.bookclub.GetAllLists(r.Context(), a.contextGetUser(r).ID, queryParametersData.Filters)
 
 	if err != nil {
 		a.serverErrResponse(w, r, err)
@@ -93,10 +112,11 @@ func (a *appDependencies) getAllLists(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := envelope{
-		"readList": readList,
+		"readList":  readList,
+		"@metadata": metadata,
 	}
 
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeJSON(w, http.StatusOK, data, a.paginationLinks(r, metadata))
 
 	if err != nil {
 		a.serverErrResponse(w, r, err)
@@ -121,15 +141,26 @@ func (a *appDependencies) listAddBook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = a.bookclub.ListAddBook(id, incomingData.BookId)
+	err = a.bookclub.ListAddBook(r.Context(), id, incomingData.BookId)
 
 	if err != nil {
-		a.serverErrResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrDuplicateListBook):
+			a.duplicateListBookResponse(w, r)
+		case errors.Is(err, data.BookNotFound):
+			v := validator.New()
+			v.AddError("bookid", "book does not exist")
+			a.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
 		return
 	}
 
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/BookId/%d", incomingData.BookId))
+	headers.Set("Location", fmt.Sprintf("/api/v1/lists/%d", id))
 
 	data := envelope{
 		"readList": incomingData,
@@ -141,9 +172,6 @@ func (a *appDependencies) listAddBook(w http.ResponseWriter, r *http.Request) {
 		a.serverErrResponse(w, r, err)
 		return
 	}
-
-	fmt.Fprintf(w, "%+v\n", incomingData)
-
 }
 
 func (a *appDependencies) getList(w http.ResponseWriter, r *http.Request) {
@@ -155,7 +183,7 @@ func (a *appDependencies) getList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	readList, err := a.bookclub.GetList(id)
+	readList, err := a.bookclub.GetList(r.Context(), id, a.contextGetUser(r).ID)
 
 	if err != nil {
 		switch {
@@ -172,7 +200,7 @@ func (a *appDependencies) getList(w http.ResponseWriter, r *http.Request) {
 		"readList": readList,
 	}
 
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeRecordJSON(w, r, http.StatusOK, data, nil, readList.Version, listLastModified(readList))
 	if err != nil {
 		a.serverErrResponse(w, r, err)
 		return
@@ -180,16 +208,80 @@ func (a *appDependencies) getList(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (a *appDependencies) putReadingList(w http.ResponseWriter, r *http.Request) {
+// getSharedList serves an unlisted list to anyone holding its share token,
+// no account needed
+func (a *appDependencies) getSharedList(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	readList, err := a.bookclub.GetSharedList(r.Context(), params.ByName("token"))
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+
+		return
+	}
+
+	data := envelope{
+		"readList": readList,
+	}
 
+	err = a.writeRecordJSON(w, r, http.StatusOK, data, nil, readList.Version, listLastModified(readList))
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+}
+
+// a list also changes when one of its books is edited
+func listLastModified(readList *data.ReadList) time.Time {
+	lastModified := readList.Updated_at
+	for _, book := range readList.Book {
+		lastModified = latest(lastModified, book.Updated_at)
+	}
+	return lastModified
+}
+
+// listInput holds the fields of a reading list its owner can write
+type listInput struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	Visibility  string `json:"visibility"`
+}
+
+func newListInput(readList *data.ReadList) listInput {
+	return listInput{
+		Name:        readList.Name,
+		Description: readList.Description,
+		Status:      readList.Status,
+		Visibility:  readList.Visibility,
+	}
+}
+
+func (i listInput) apply(readList *data.ReadList) {
+	readList.Name = i.Name
+	readList.Description = i.Description
+	readList.Status = i.Status
+	readList.Visibility = i.Visibility
+}
+
+// editableList loads the list named in the URL and checks If-Match against
+// it. It returns nil once a response has been sent.
+func (a *appDependencies) editableList(w http.ResponseWriter, r *http.Request) *data.ReadList {
 	id, err := a.readIDParam(r)
 
 	if err != nil {
 		a.notFoundResponse(w, r)
-		return
+		return nil
 	}
 
-	readList, err := a.bookclub.GetList(id)
+	readList, err := a.bookclub.GetList(r.Context(), id, a.contextGetUser(r).ID)
 
 	if err != nil {
 		switch {
@@ -199,71 +291,303 @@ func (a *appDependencies) putReadingList(w http.ResponseWriter, r *http.Request)
 			a.serverErrResponse(w, r, err)
 		}
 
-		return
+		return nil
 	}
 
-	var incomingData struct {
-		Name        *string `json:"name"`
-		Description *string `json:"description"`
-		Created_by  *string `json:"created_by"` //takes an intiger
-		Status      *string `json:"status"`
+	if !a.checkIfMatch(w, r, readList.Version) {
+		return nil
 	}
 
-	err = a.readJSON(w, r, &incomingData)
+	return readList
+}
+
+// saveList validates and stores an edited list, then reads it back so the
+// body and ETag match what getList will serve. It returns nil once a
+// response has been sent.
+func (a *appDependencies) saveList(w http.ResponseWriter, r *http.Request, readList *data.ReadList) *data.ReadList {
+	v := validator.New()
+
+	data.ValidateList(v, readList)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return nil
+	}
+
+	status, err := a.lookupStatus(r.Context(), v, readList.Status)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return nil
+	}
+
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return nil
+	}
+
+	err = a.bookclub.UpdateList(r.Context(), readList, readList.ID, status.ID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return nil
+	}
+
+	readList, err = a.bookclub.GetList(r.Context(), readList.ID, a.contextGetUser(r).ID)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return nil
+	}
+
+	return readList
+}
+
+// putReadingList replaces the name, description, status and visibility of
+// a list
+func (a *appDependencies) putReadingList(w http.ResponseWriter, r *http.Request) {
+	readList := a.editableList(w, r)
+	if readList == nil {
+		return
+	}
+
+	var incomingData listInput
+
+	err := a.readJSON(w, r, &incomingData)
 
 	if err != nil {
 		a.badRequestResponse(w, r, err)
 		return
 	}
 
-	if incomingData.Name != nil {
-		readList.Name = *incomingData.Name
+	incomingData.apply(readList)
+
+	readList = a.saveList(w, r, readList)
+	if readList == nil {
+		return
+	}
+
+	data := envelope{
+		"readList": readList,
+	}
+
+	err = a.writeRecordJSON(w, r, http.StatusOK, data, nil, readList.Version, listLastModified(readList))
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+}
+
+// patchReadingList applies a JSON merge patch to a list
+func (a *appDependencies) patchReadingList(w http.ResponseWriter, r *http.Request) {
+	readList := a.editableList(w, r)
+	if readList == nil {
+		return
+	}
+
+	incomingData := newListInput(readList)
+
+	changed, err := a.readMergePatch(w, r, &incomingData)
+
+	if err != nil {
+		a.patchErrorResponse(w, r, err)
+		return
+	}
+
+	// nothing to store, and no reason to bump the version
+	if len(changed) > 0 {
+		incomingData.apply(readList)
+
+		readList = a.saveList(w, r, readList)
+		if readList == nil {
+			return
+		}
+	}
+
+	err = a.writePatchedJSON(w, "readList", readList, readList.Version, changed, listLastModified(readList))
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+}
+
+// listBookInput holds the progress fields of a list entry. Dates and
+// progress can be cleared with null.
+type listBookInput struct {
+	Status      string     `json:"status"`
+	CurrentPage *int       `json:"current_page"`
+	Percent     *float64   `json:"percent"`
+	Started_at  *time.Time `json:"started_at"`
+	Finished_at *time.Time `json:"finished_at"`
+}
+
+func newListBookInput(book *data.ListBook) listBookInput {
+	return listBookInput{
+		Status:      book.Status,
+		CurrentPage: book.CurrentPage,
+		Percent:     book.Percent,
+		Started_at:  book.Started_at,
+		Finished_at: book.Finished_at,
+	}
+}
+
+func (i listBookInput) apply(book *data.ListBook) {
+	book.Status = i.Status
+	book.CurrentPage = i.CurrentPage
+	book.Percent = i.Percent
+	book.Started_at = i.Started_at
+	book.Finished_at = i.Finished_at
+}
+
+// patchListBook applies a JSON merge patch to the status and progress of a
+// book on a list
+func (a *appDependencies) patchListBook(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
 	}
 
-	if incomingData.Description != nil {
-		readList.Description = *incomingData.Description
+	bid, err := a.readNamedIDParam(r, "book_id")
+
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
 	}
 
-	var uid int64
-	if incomingData.Created_by != nil {
+	book, err := a.bookclub.GetListBook(r.Context(), id, bid)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+
+		return
+	}
 
-		uid, err = toInt(*incomingData.Created_by)
+	incomingData := newListBookInput(book)
+
+	changed, err := a.readMergePatch(w, r, &incomingData)
+
+	if err != nil {
+		a.patchErrorResponse(w, r, err)
+		return
+	}
+
+	if len(changed) > 0 {
+		incomingData.apply(book)
+
+		// moving into reading or finished dates the entry unless the
+		// client sent the date itself
+		now := time.Now().Truncate(time.Second)
+		if book.Status == data.EntryReading || book.Status == data.EntryFinished {
+			if book.Started_at == nil && !slices.Contains(changed, "started_at") {
+				book.Started_at = &now
+			}
+		}
+		if book.Status == data.EntryFinished && book.Finished_at == nil && !slices.Contains(changed, "finished_at") {
+			book.Finished_at = &now
+		}
+
+		v := validator.New()
+
+		data.ValidateListBook(v, book)
+		if !v.IsEmpty() {
+			a.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		err = a.bookclub.UpdateListBook(r.Context(), id, book)
 
 		if err != nil {
-			a.badRequestResponse(w, r, err)
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				a.notFoundResponse(w, r)
+			default:
+				a.serverErrResponse(w, r, err)
+			}
+			return
 		}
+	}
 
-	} else {
-		uid = 0
+	data := envelope{
+		"book":    book,
+		"changed": changed,
 	}
 
-	if incomingData.Status != nil {
-		readList.Status = *incomingData.Status
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
 	}
 
-	var status int64
+}
 
-	log.Println(readList.Status)
-	if readList.Status == "Completed" {
-		status = 2
-	} else if readList.Status == "Currently Reading" {
-		status = 1
-	} else {
-		err := errors.New("unable to find Status")
+// putListOrder takes either the full order of the list's books as
+// book_ids, or a single book_id to move to position
+func (a *appDependencies) putListOrder(w http.ResponseWriter, r *http.Request) {
+	readList := a.editableList(w, r)
+	if readList == nil {
+		return
+	}
+
+	var incomingData struct {
+		BookIDs  []int64 `json:"book_ids"`
+		BookID   *int64  `json:"book_id"`
+		Position *int    `json:"position"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+
+	if err != nil {
 		a.badRequestResponse(w, r, err)
+		return
 	}
 
 	v := validator.New()
 
-	data.ValidateList(v, readList)
+	if incomingData.BookIDs != nil {
+		v.Check(incomingData.BookID == nil && incomingData.Position == nil, "book_ids", "must not be sent with book_id or position")
+		v.Check(validator.Unique(incomingData.BookIDs), "book_ids", "must not contain duplicate values")
+	} else {
+		v.Check(incomingData.BookID != nil, "book_id", "must be provided")
+		v.Check(incomingData.Position != nil, "position", "must be provided")
+		v.Check(incomingData.Position == nil || *incomingData.Position >= 1, "position", "must be at least 1")
+	}
+
 	if !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors)
-
 		return
 	}
 
-	err = a.bookclub.UpdateList(readList, id, uid, status)
+	if incomingData.BookIDs != nil {
+		err = a.bookclub.ReorderList(r.Context(), readList.ID, incomingData.BookIDs)
+	} else {
+		err = a.bookclub.MoveListBook(r.Context(), readList.ID, *incomingData.BookID, *incomingData.Position)
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidOrder):
+			v.AddError("book_ids", "must contain every book on the list exactly once")
+			a.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
+	}
 
+	readList, err = a.bookclub.GetList(r.Context(), readList.ID, a.contextGetUser(r).ID)
 	if err != nil {
 		a.serverErrResponse(w, r, err)
 		return
@@ -273,7 +597,7 @@ func (a *appDependencies) putReadingList(w http.ResponseWriter, r *http.Request)
 		"readList": readList,
 	}
 
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeRecordJSON(w, r, http.StatusOK, data, nil, readList.Version, listLastModified(readList))
 	if err != nil {
 		a.serverErrResponse(w, r, err)
 		return
@@ -290,7 +614,7 @@ func (a *appDependencies) deleteList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = a.bookclub.DeleteList(id)
+	err = a.bookclub.DeleteList(r.Context(), id)
 
 	if err != nil {
 		switch {
@@ -332,7 +656,11 @@ func (a *appDependencies) deleteFromList(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = a.bookclub.DeleteFromList(id, incomingData.List_id)
+	if !a.checkListOwner(w, r, incomingData.List_id) {
+		return
+	}
+
+	err = a.bookclub.DeleteFromList(r.Context(), id, incomingData.List_id)
 
 	if err != nil {
 		switch {
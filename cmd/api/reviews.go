@@ -19,14 +19,19 @@ func (a *appDependencies) postReview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err = a.bookclub.GetBook(id)
+	_, err = a.bookclub.GetBook(r.Context(), id)
 
 	if err != nil {
-		a.bookNotFound(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
 	}
 
 	var incomingData struct {
-		User_id    int64   `json:"user_id"`
 		Review     string  `json:"review"`
 		Created_at string  `json:"created_at"`
 		Rating     float64 `json:"rating"`
@@ -39,7 +44,7 @@ func (a *appDependencies) postReview(w http.ResponseWriter, r *http.Request) {
 	}
 	review := &data.ReviewIn{
 		Book_id:    id,
-		User_id:    incomingData.User_id,
+		User_id:    a.contextGetUser(r).ID,
 		Review:     incomingData.Review,
 		Created_at: time.Now(),
 		Rating:     incomingData.Rating,
@@ -53,16 +58,15 @@ func (a *appDependencies) postReview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = a.bookclub.InsertReview(review)
+	err = a.bookclub.InsertReview(r.Context(), review)
 
 	if err != nil {
-		a.hello()
 		a.serverErrResponse(w, r, err)
 		return
 	}
 
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/review/%d", review.ID))
+	headers.Set("Location", fmt.Sprintf("/api/v1/reviews/%d", review.ID))
 
 	data := envelope{
 		"review": review,
@@ -74,9 +78,6 @@ func (a *appDependencies) postReview(w http.ResponseWriter, r *http.Request) {
 		a.serverErrResponse(w, r, err)
 		return
 	}
-
-	fmt.Fprintf(w, "%+v\n", incomingData)
-
 }
 
 func (a *appDependencies) getReviews(w http.ResponseWriter, r *http.Request) {
@@ -105,6 +106,7 @@ func (a *appDependencies) getReviews(w http.ResponseWriter, r *http.Request) {
 
 	// queryParametersData.Filters.SortSafeList = []string{"id", "rating", "helpful_count", "created_at", "updated_at", "-id", "-rating", "-helpful_count", "-created_at", "-updated_at"}
 	queryParametersData.Filters.SortSafeList = []string{"id", "-id"}
+	queryParametersData.Filters.Cursor = a.getSingleQueryParameters(queryParameters, "cursor", "")
 
 	v := validator.New()
 
@@ -124,7 +126,7 @@ func (a *appDependencies) getReviews(w http.ResponseWriter, r *http.Request) {
 	// 	return
 	// }
 
-	review, err := a.bookclub.GetAllReviews(queryParametersData.Filters, queryParametersData.ID)
+	review, metadata, err := a.bookclub.GetAllReviews(r.Context(), queryParametersData.Filters, queryParametersData.ID)
 
 	if err != nil {
 		a.serverErrResponse(w, r, err)
@@ -132,17 +134,23 @@ func (a *appDependencies) getReviews(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := envelope{
-		"review": review,
+		"review":    review,
+		"@metadata": metadata,
 	}
 
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	lastModified := time.Time{}
+	for _, item := range review {
+		lastModified = latest(lastModified, item.Updated_at)
+	}
+
+	err = a.writeConditionalJSON(w, r, http.StatusOK, data, a.paginationLinks(r, metadata), lastModified)
 
 	if err != nil {
 		a.serverErrResponse(w, r, err)
 	}
 }
 
-func (a *appDependencies) deleteReview(w http.ResponseWriter, r *http.Request) {
+func (a *appDependencies) getReview(w http.ResponseWriter, r *http.Request) {
 
 	id, err := a.readIDParam(r)
 
@@ -151,7 +159,7 @@ func (a *appDependencies) deleteReview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = a.bookclub.DeleteReview(id)
+	review, err := a.bookclub.GetReview(r.Context(), id)
 
 	if err != nil {
 		switch {
@@ -165,17 +173,16 @@ func (a *appDependencies) deleteReview(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := envelope{
-		"message": "review successfully deleted",
+		"review": review,
 	}
 
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeRecordJSON(w, r, http.StatusOK, data, nil, review.Version, review.Updated_at)
 	if err != nil {
 		a.serverErrResponse(w, r, err)
 	}
-
 }
 
-func (a *appDependencies) putReview(w http.ResponseWriter, r *http.Request) {
+func (a *appDependencies) deleteReview(w http.ResponseWriter, r *http.Request) {
 
 	id, err := a.readIDParam(r)
 
@@ -184,7 +191,7 @@ func (a *appDependencies) putReview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	review, err := a.bookclub.GetReview(id)
+	err = a.bookclub.DeleteReview(r.Context(), id)
 
 	if err != nil {
 		switch {
@@ -197,39 +204,110 @@ func (a *appDependencies) putReview(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var incomingData struct {
-		Review *string  `json:"review"`
-		Rating *float64 `json:"rating"`
+	data := envelope{
+		"message": "review successfully deleted",
 	}
 
-	err = a.readJSON(w, r, &incomingData)
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+	}
+
+}
+
+// reviewInput holds the fields of a review its author can write
+type reviewInput struct {
+	Review string  `json:"review"`
+	Rating float64 `json:"rating"`
+}
+
+func newReviewInput(review *data.ReviewIn) reviewInput {
+	return reviewInput{
+		Review: review.Review,
+		Rating: review.Rating,
+	}
+}
+
+func (i reviewInput) apply(review *data.ReviewIn) {
+	review.Review = i.Review
+	review.Rating = i.Rating
+}
+
+// editableReview loads the review named in the URL and checks If-Match
+// against it. It returns nil once a response has been sent.
+func (a *appDependencies) editableReview(w http.ResponseWriter, r *http.Request) *data.ReviewIn {
+	id, err := a.readIDParam(r)
 
 	if err != nil {
-		a.badRequestResponse(w, r, err)
-		return
+		a.notFoundResponse(w, r)
+		return nil
 	}
 
-	if incomingData.Review != nil {
-		review.Review = *incomingData.Review
+	review, err := a.bookclub.GetReview(r.Context(), id)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+
+		return nil
 	}
 
-	if incomingData.Rating != nil {
-		review.Rating = *incomingData.Rating
+	if !a.checkIfMatch(w, r, review.Version) {
+		return nil
 	}
 
+	return review
+}
+
+// saveReview validates and stores an edited review. It returns false once
+// a response has been sent.
+func (a *appDependencies) saveReview(w http.ResponseWriter, r *http.Request, review *data.ReviewIn) bool {
 	v := validator.New()
 
 	data.ValidateReview(v, review)
 	if !v.IsEmpty() {
 		a.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	err := a.bookclub.UpdateReview(r.Context(), review, review.ID)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return false
+	}
+
+	return true
+}
 
+// putReview replaces the text and rating of a review
+func (a *appDependencies) putReview(w http.ResponseWriter, r *http.Request) {
+	review := a.editableReview(w, r)
+	if review == nil {
 		return
 	}
 
-	err = a.bookclub.UpdateReview(review, id)
+	var incomingData reviewInput
+
+	err := a.readJSON(w, r, &incomingData)
 
 	if err != nil {
-		a.serverErrResponse(w, r, err)
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	incomingData.apply(review)
+
+	if !a.saveReview(w, r, review) {
 		return
 	}
 
@@ -237,10 +315,87 @@ func (a *appDependencies) putReview(w http.ResponseWriter, r *http.Request) {
 		"review": review,
 	}
 
-	err = a.writeJSON(w, http.StatusOK, data, nil)
+	err = a.writeRecordJSON(w, r, http.StatusOK, data, nil, review.Version, review.Updated_at)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+}
+
+// patchReview applies a JSON merge patch to a review
+func (a *appDependencies) patchReview(w http.ResponseWriter, r *http.Request) {
+	review := a.editableReview(w, r)
+	if review == nil {
+		return
+	}
+
+	incomingData := newReviewInput(review)
+
+	changed, err := a.readMergePatch(w, r, &incomingData)
+
+	if err != nil {
+		a.patchErrorResponse(w, r, err)
+		return
+	}
+
+	// nothing to store, and no reason to bump the version
+	if len(changed) > 0 {
+		incomingData.apply(review)
+
+		if !a.saveReview(w, r, review) {
+			return
+		}
+	}
+
+	err = a.writePatchedJSON(w, "review", review, review.Version, changed, review.Updated_at)
 	if err != nil {
 		a.serverErrResponse(w, r, err)
 		return
 	}
 
 }
+
+func (a *appDependencies) searchReviews(w http.ResponseWriter, r *http.Request) {
+	var queryParametersData struct {
+		Query string
+		data.Filters
+	}
+
+	queryParameters := r.URL.Query()
+	queryParametersData.Query = a.getSingleQueryParameters(queryParameters, "q", "")
+
+	queryParametersData.Filters.Sort = a.getSingleQueryParameters(queryParameters, "sort", "-rank")
+	queryParametersData.Filters.SortSafeList = []string{"rank", "id", "-rank", "-id"}
+	queryParametersData.Filters.Cursor = a.getSingleQueryParameters(queryParameters, "cursor", "")
+
+	v := validator.New()
+
+	queryParametersData.Filters.Page = a.getSingleIntegerParameters(queryParameters, "page", 1, v)
+	queryParametersData.Filters.PageSize = a.getSingleIntegerParameters(queryParameters, "page_size", 10, v)
+
+	data.ValidateReviewSearch(v, queryParametersData.Query)
+	data.ValidateFilters(v, queryParametersData.Filters)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	reviews, metadata, err := a.bookclub.SearchReviews(r.Context(), queryParametersData.Query, queryParametersData.Filters)
+
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"reviews":   reviews,
+		"@metadata": metadata,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, a.paginationLinks(r, metadata))
+
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+	}
+}
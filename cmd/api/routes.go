@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	"github.com/Jcastel2014/test3/internal/data"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -13,17 +14,50 @@ func (a *appDependencies) routes() http.Handler {
 	router.MethodNotAllowed = http.HandlerFunc(a.notAllowedResponse)
 
 	// GET    /api/v1/books              # List all books with pagination
-	router.HandlerFunc(http.MethodGet, "/api/v1/books", a.requireActivatedUser(a.GetAllBooks))
+	router.HandlerFunc(http.MethodGet, "/api/v1/books", a.requirePermission(data.PermissionBooksRead, a.GetAllBooks))
+	// GET    /api/v1/books/search       # Ranked full-text search over books
 	// GET    /api/v1/books/{id}         # Get book details
-	router.HandlerFunc(http.MethodGet, "/api/v1/books/:id", a.requireActivatedUser(a.getBook))
+	router.HandlerFunc(http.MethodGet, "/api/v1/books/:id", a.requirePermission(data.PermissionBooksRead, a.dispatch("id", map[string]http.HandlerFunc{
+		"search": a.searchBook,
+	}, a.getBook)))
+	// GET    /api/v1/books/isbn/{isbn}  # Look a book up by ISBN-10 or ISBN-13
+	// GET    /api/v1/books/{id}/reviews # Get all reviews for a book
+	// GET    /api/v1/books/{id}/cover   # Get the cover image, size=thumbnail for the small one
+	// GET    /api/v1/books/import/{id}  # Poll a bulk import and its report
+	router.HandlerFunc(http.MethodGet, "/api/v1/books/:id/:sub", a.requirePermission(data.PermissionBooksRead, a.dispatch("id", map[string]http.HandlerFunc{
+		"isbn":   a.getBookByISBN,
+		"import": a.requirePermission(data.PermissionBooksWrite, a.getBookImport),
+	}, a.dispatch("sub", map[string]http.HandlerFunc{
+		"reviews": a.getReviews,
+		"cover":   a.getBookCover,
+	}, a.notFoundResponse))))
 	// POST   /api/v1/books              # Add new book
-	router.HandlerFunc(http.MethodPost, "/api/v1/books", a.requireActivatedUser(a.postBook))
+	router.HandlerFunc(http.MethodPost, "/api/v1/books", a.requirePermission(data.PermissionBooksWrite, a.postBook))
+	// POST   /api/v1/books/import       # Bulk import books from CSV or NDJSON
+	router.HandlerFunc(http.MethodPost, "/api/v1/books/:id", a.requirePermission(data.PermissionBooksWrite, a.dispatch("id", map[string]http.HandlerFunc{
+		"import": a.postBookImport,
+	}, a.notFoundResponse)))
 	// PUT    /api/v1/books/{id}         # Update book details
-	router.HandlerFunc(http.MethodPut, "/api/v1/books/:id", a.requireActivatedUser(a.PutBook))
+	router.HandlerFunc(http.MethodPut, "/api/v1/books/:id", a.requirePermission(data.PermissionBooksWrite, a.PutBook))
+	router.HandlerFunc(http.MethodPatch, "/api/v1/books/:id", a.requirePermission(data.PermissionBooksWrite, a.patchBook))
+	// PUT    /api/v1/books/{id}/cover   # Upload a JPEG or PNG cover
+	router.HandlerFunc(http.MethodPut, "/api/v1/books/:id/cover", a.requirePermission(data.PermissionBooksWrite, a.putBookCover))
 	// DELETE /api/v1/books/{id}         # Delete book
-	router.HandlerFunc(http.MethodDelete, "/api/v1/books/:id", a.requireActivatedUser(a.deleteBook))
-	// GET    /api/v1/books/search       # Search books by title/author/genre
-	router.HandlerFunc(http.MethodGet, "/api/v1/book/search", a.requireActivatedUser(a.searchBook))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/books/:id", a.requirePermission(data.PermissionBooksWrite, a.deleteBook))
+
+	// GET    /api/v1/autocomplete       # Suggest titles and authors while typing
+	router.HandlerFunc(http.MethodGet, "/api/v1/autocomplete", a.requirePermission(data.PermissionBooksRead, a.autocomplete))
+
+	// GET    /api/v1/authors            # List authors
+	router.HandlerFunc(http.MethodGet, "/api/v1/authors", a.requirePermission(data.PermissionBooksRead, a.getAllAuthors))
+	// GET    /api/v1/authors/{id}       # Get an author and their bibliography
+	router.HandlerFunc(http.MethodGet, "/api/v1/authors/:id", a.requirePermission(data.PermissionBooksRead, a.getAuthor))
+	// POST   /api/v1/authors            # Add new author
+	router.HandlerFunc(http.MethodPost, "/api/v1/authors", a.requirePermission(data.PermissionBooksWrite, a.postAuthor))
+	// PUT    /api/v1/authors/{id}       # Rename author
+	router.HandlerFunc(http.MethodPut, "/api/v1/authors/:id", a.requirePermission(data.PermissionBooksWrite, a.putAuthor))
+	// DELETE /api/v1/authors/{id}       # Delete author with no books
+	router.HandlerFunc(http.MethodDelete, "/api/v1/authors/:id", a.requirePermission(data.PermissionBooksWrite, a.deleteAuthor))
 
 	// GET    /api/v1/lists              # Get all reading lists
 	router.HandlerFunc(http.MethodGet, "/api/v1/lists", a.requireActivatedUser(a.getAllLists))
@@ -32,26 +66,47 @@ func (a *appDependencies) routes() http.Handler {
 	// POST   /api/v1/lists              # Create new reading list
 	router.HandlerFunc(http.MethodPost, "/api/v1/lists", a.requireActivatedUser(a.postReadingList))
 	// PUT    /api/v1/lists/{id}         # Update reading list
-	router.HandlerFunc(http.MethodPut, "/api/v1/lists/:id", a.requireActivatedUser(a.putReadingList))
+	router.HandlerFunc(http.MethodPut, "/api/v1/lists/:id", a.requireListOwner(a.putReadingList))
+	router.HandlerFunc(http.MethodPatch, "/api/v1/lists/:id", a.requireListOwner(a.patchReadingList))
 	// DELETE /api/v1/lists/{id}         # Delete reading list
-	router.HandlerFunc(http.MethodDelete, "/api/v1/lists/:id", a.requireActivatedUser(a.deleteList))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/lists/:id", a.requireListOwner(a.deleteList))
 	// POST   /api/v1/lists/{id}/books   # Add book to reading list
-	router.HandlerFunc(http.MethodPost, "/api/v1/lists/:id/books", a.requireActivatedUser(a.listAddBook))
+	router.HandlerFunc(http.MethodPost, "/api/v1/lists/:id/books", a.requireListOwner(a.listAddBook))
 	// DELETE /api/v1/lists/{id}/books   # Remove book from reading list
 	router.HandlerFunc(http.MethodDelete, "/api/v1/lists/:id/books", a.requireActivatedUser(a.deleteFromList))
+	// PATCH  /api/v1/lists/{id}/books/{book_id}  # Update reading status and progress of a book
+	router.HandlerFunc(http.MethodPatch, "/api/v1/lists/:id/books/:book_id", a.requireListOwner(a.patchListBook))
+	// PUT    /api/v1/lists/{id}/order   # Reorder the books of a reading list
+	router.HandlerFunc(http.MethodPut, "/api/v1/lists/:id/order", a.requireListOwner(a.putListOrder))
+	// GET    /api/v1/shared/lists/{token}  # Get an unlisted reading list through its share link
+	router.HandlerFunc(http.MethodGet, "/api/v1/shared/lists/:token", a.getSharedList)
 
-	// GET    /api/v1/books/{id}/reviews # Get all reviews for a book
-	router.HandlerFunc(http.MethodGet, "/api/v1/books/:id/reviews", a.requireActivatedUser(a.getReviews))
 	// POST   /api/v1/books/{id}/reviews # Add new review
 	router.HandlerFunc(http.MethodPost, "/api/v1/books/:id/reviews", a.requireActivatedUser(a.postReview))
+	// GET    /api/v1/reviews/search     # Ranked full-text search over reviews
+	// GET    /api/v1/reviews/{id}       # Get a review, its ETag is what updates take in If-Match
+	router.HandlerFunc(http.MethodGet, "/api/v1/reviews/:id", a.requirePermission(data.PermissionBooksRead, a.dispatch("id", map[string]http.HandlerFunc{
+		"search": a.searchReviews,
+	}, a.getReview)))
 	// PUT    /api/v1/reviews/{id}       # Update review
-	router.HandlerFunc(http.MethodPut, "/api/v1/reviews/:id", a.requireActivatedUser(a.putReview))
+	router.HandlerFunc(http.MethodPut, "/api/v1/reviews/:id", a.requireReviewOwner(a.putReview))
+	router.HandlerFunc(http.MethodPatch, "/api/v1/reviews/:id", a.requireReviewOwner(a.patchReview))
 	// DELETE /api/v1/reviews/{id}       # Delete review
-	router.HandlerFunc(http.MethodDelete, "/api/v1/reviews/:id", a.requireActivatedUser(a.deleteReview))
+	router.HandlerFunc(http.MethodDelete, "/api/v1/reviews/:id", a.requireReviewOwner(a.deleteReview))
 
 	router.HandlerFunc(http.MethodPost, "/v1/users", a.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", a.activateUserHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", a.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", a.createPasswordResetTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", a.createActivationTokenHandler)
+	// GET    /v1/tokens                 # List the user's active sessions
+	router.HandlerFunc(http.MethodGet, "/v1/tokens", a.requireAuthenticatedUser(a.listAuthenticationTokensHandler))
+	// DELETE /v1/tokens/authentication  # Log out of the current session
+	// DELETE /v1/tokens/{id}            # Revoke one of the user's sessions
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/:id", a.requireAuthenticatedUser(a.dispatch("id", map[string]http.HandlerFunc{
+		"authentication": a.deleteCurrentTokenHandler,
+	}, a.deleteTokenHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", a.updateUserPasswordHandler)
 
 	// GET    /api/v1/users/{id}         # Get user profile
 	router.HandlerFunc(http.MethodGet, "/api/v1/users/:id", a.requireActivatedUser(a.getUser))
@@ -59,6 +114,13 @@ func (a *appDependencies) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/api/v1/users/:id/lists", a.requireActivatedUser(a.getUserLists))
 	// GET    /api/v1/users/{id}/reviews # Get user's reviews
 	router.HandlerFunc(http.MethodGet, "/api/v1/users/:id/reviews", a.requireActivatedUser(a.GetUserReviews))
+	// PUT    /api/v1/users/{id}/role    # Change a user's role (admin only)
+	router.HandlerFunc(http.MethodPut, "/api/v1/users/:id/role", a.requirePermission(data.PermissionAdmin, a.putUserRole))
+
+	// GET    /api/v1/statuses           # Get the reading list statuses (admin only)
+	router.HandlerFunc(http.MethodGet, "/api/v1/statuses", a.requirePermission(data.PermissionAdmin, a.getStatuses))
+	// POST   /api/v1/statuses           # Add a reading list status (admin only)
+	router.HandlerFunc(http.MethodPost, "/api/v1/statuses", a.requirePermission(data.PermissionAdmin, a.postStatus))
 
 	return a.recoverPanic(a.rateLimit(a.authenticate(router)))
 }
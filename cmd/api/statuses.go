@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Jcastel2014/test3/internal/data"
+	"github.com/Jcastel2014/test3/internal/validator"
+)
+
+func (a *appDependencies) getStatuses(w http.ResponseWriter, r *http.Request) {
+	statuses, err := a.bookclub.GetAllStatuses(r.Context())
+
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"statuses": statuses,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+	}
+}
+
+func (a *appDependencies) postStatus(w http.ResponseWriter, r *http.Request) {
+
+	var incomingData struct {
+		Name string `json:"name"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	status := &data.Status{
+		Name: strings.TrimSpace(incomingData.Name),
+	}
+
+	v := validator.New()
+	data.ValidateStatus(v, status)
+
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = a.bookclub.InsertStatus(r.Context(), status)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateStatus):
+			v.AddError("name", "a status with this name already exists")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/statuses/%d", status.ID))
+
+	data := envelope{
+		"status": status,
+	}
+
+	err = a.writeJSON(w, http.StatusCreated, data, headers)
+
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+}
+
+// lookupStatus finds a list status by name. A name that isn't in the status
+// table is recorded on v and comes back nil without an error.
+func (a *appDependencies) lookupStatus(ctx context.Context, v *validator.Validator, name string) (*data.Status, error) {
+	status, err := a.bookclub.GetStatusByName(ctx, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("status", "must be an existing list status")
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return status, nil
+}
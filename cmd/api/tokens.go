@@ -1,6 +1,9 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"net/http"
 	"time"
@@ -30,7 +33,7 @@ func (a *appDependencies) createAuthenticationTokenHandler(w http.ResponseWriter
 		return
 	}
 
-	user, err := a.userModel.GetByEmail(incomingData.Email)
+	user, err := a.userModel.GetByEmail(r.Context(), incomingData.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -52,7 +55,7 @@ func (a *appDependencies) createAuthenticationTokenHandler(w http.ResponseWriter
 		return
 	}
 
-	token, err := a.tokenModel.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	token, err := a.tokenModel.NewAuthentication(r.Context(), user.ID, 24*time.Hour, r.UserAgent(), a.clientIP(r))
 	if err != nil {
 		a.serverErrResponse(w, r, err)
 		return
@@ -69,3 +72,221 @@ func (a *appDependencies) createAuthenticationTokenHandler(w http.ResponseWriter
 	}
 
 }
+
+func (a *appDependencies) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Email string `json:"email"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, incomingData.Email)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Like createActivationTokenHandler the lookup and the token insert run
+	// in the background, so the response is the same, and takes the same
+	// time, whether or not the address belongs to an activated account.
+	a.background(func() {
+		//the request context is cancelled as soon as the response is sent
+		ctx := context.Background()
+
+		user, err := a.userModel.GetByEmail(ctx, incomingData.Email)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				a.logger.Error(err.Error())
+			}
+			return
+		}
+
+		if !user.Activated {
+			return
+		}
+
+		token, err := a.tokenModel.New(ctx, user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			a.logger.Error(err.Error())
+			return
+		}
+
+		data := map[string]any{
+			"passwordResetToken": token.PlainText,
+		}
+		err = a.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	})
+
+	data := envelope{
+		"message": "if an activated account uses that email, an email will be sent to you containing password reset instructions",
+	}
+
+	err = a.writeJSON(w, http.StatusAccepted, data, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+}
+
+func (a *appDependencies) listAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := a.contextGetUser(r)
+
+	tokens, err := a.tokenModel.GetAllForUser(r.Context(), data.ScopeAuthentication, user.ID)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	currentHash := sha256.Sum256([]byte(a.contextGetToken(r)))
+
+	sessions := make([]data.Session, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, data.Session{
+			Token:   token,
+			Current: bytes.Equal(token.Hash, currentHash[:]),
+		})
+	}
+
+	data := envelope{
+		"sessions": sessions,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+}
+
+// revoke the token the request was authenticated with, i.e. log out
+func (a *appDependencies) deleteCurrentTokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := a.tokenModel.Delete(r.Context(), data.ScopeAuthentication, a.contextGetToken(r))
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"message": "you have been logged out",
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+}
+
+func (a *appDependencies) deleteTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	user := a.contextGetUser(r)
+
+	err = a.tokenModel.DeleteForUser(r.Context(), data.ScopeAuthentication, id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
+	}
+
+	data := envelope{
+		"message": "session successfully revoked",
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+}
+
+func (a *appDependencies) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var incomingData struct {
+		Email string `json:"email"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, incomingData.Email)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	/*	The lookup happens in the background as well as the email so that the
+		response is the same, and takes the same time, whether or not the
+		address belongs to an account. Otherwise this endpoint could be used to
+		find out which emails are registered.
+	*/
+	a.background(func() {
+		//the request context is cancelled as soon as the response is sent
+		ctx := context.Background()
+
+		user, err := a.userModel.GetByEmail(ctx, incomingData.Email)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				a.logger.Error(err.Error())
+			}
+			return
+		}
+
+		if user.Activated {
+			return
+		}
+
+		//only the newest activation token should work
+		err = a.tokenModel.DeleteAllForUser(ctx, data.ScopeActivation, user.ID)
+		if err != nil {
+			a.logger.Error(err.Error())
+			return
+		}
+
+		token, err := a.tokenModel.New(ctx, user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			a.logger.Error(err.Error())
+			return
+		}
+
+		data := map[string]any{
+			"activationToken": token.PlainText,
+			"userID":          user.ID,
+		}
+		err = a.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		if err != nil {
+			a.logger.Error(err.Error())
+		}
+	})
+
+	data := envelope{
+		"message": "if an account with that email needs activating, an email will be sent to you containing activation instructions",
+	}
+
+	err = a.writeJSON(w, http.StatusAccepted, data, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"time"
@@ -42,7 +43,8 @@ func (a *appDependencies) registerUserHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = a.userModel.Insert(user)
+	//every new account starts out as a member
+	err = a.userModel.Insert(r.Context(), user, data.Roles["member"]...)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -55,7 +57,7 @@ func (a *appDependencies) registerUserHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	//new activation token to expire in 3 days time
-	token, err := a.tokenModel.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := a.tokenModel.New(r.Context(), user.ID, 3*24*time.Hour, data.ScopeActivation)
 	if err != nil {
 		a.serverErrResponse(w, r, err)
 		return
@@ -89,6 +91,32 @@ func (a *appDependencies) registerUserHandler(w http.ResponseWriter, r *http.Req
 	}
 }
 
+// bootstrapAdmin gives the admin role to the user registered with email.
+// Nobody can reach PUT /api/v1/users/:id/role without an admin, so the first
+// one is set up from the -admin-email flag. The account has to be
+// registered first, until then the server only warns.
+func (a *appDependencies) bootstrapAdmin(ctx context.Context, email string) error {
+	user, err := a.userModel.GetByEmail(ctx, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.logger.Warn("admin account not registered yet", "email", email)
+			return nil
+		default:
+			return err
+		}
+	}
+
+	err = a.permissionModel.SetForUser(ctx, user.ID, data.Roles["admin"]...)
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info("admin role granted", "email", email, "user_id", user.ID)
+
+	return nil
+}
+
 func (a *appDependencies) activateUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	var incomingData struct {
@@ -108,11 +136,11 @@ func (a *appDependencies) activateUserHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	user, err := a.userModel.GetForToken(data.ScopeActivation, incomingData.TokenPlainText)
+	user, err := a.userModel.GetForToken(r.Context(), data.ScopeActivation, incomingData.TokenPlainText)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
-			v.AddError("token", "invalid or expired activation token")
+			v.AddError("token", "invalid or expired activation token, a new one can be requested at POST /v1/tokens/activation")
 			a.failedValidationResponse(w, r, v.Errors)
 		default:
 			a.serverErrResponse(w, r, err)
@@ -121,7 +149,7 @@ func (a *appDependencies) activateUserHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	user.Activated = true
-	err = a.userModel.Update(user)
+	err = a.userModel.Update(r.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -132,7 +160,7 @@ func (a *appDependencies) activateUserHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err = a.tokenModel.DeleteAllForUser(data.ScopeActivation, user.ID)
+	err = a.tokenModel.DeleteAllForUser(r.Context(), data.ScopeActivation, user.ID)
 	if err != nil {
 		a.serverErrResponse(w, r, err)
 		return
@@ -148,6 +176,80 @@ func (a *appDependencies) activateUserHandler(w http.ResponseWriter, r *http.Req
 	}
 }
 
+func (a *appDependencies) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+
+	var incomingData struct {
+		Password       string `json:"password"`
+		TokenPlainText string `json:"token"`
+	}
+
+	err := a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	data.ValidatePassword(v, incomingData.Password)
+	data.ValidatetokenPlaintext(v, incomingData.TokenPlainText)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.userModel.GetForToken(r.Context(), data.ScopePasswordReset, incomingData.TokenPlainText)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			a.failedValidationResponse(w, r, v.Errors)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(incomingData.Password)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	err = a.userModel.Update(r.Context(), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			a.editConflictResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
+	}
+
+	//the reset token is single use
+	err = a.tokenModel.DeleteAllForUser(r.Context(), data.ScopePasswordReset, user.ID)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	//anyone logged in with the old password gets signed out
+	err = a.tokenModel.DeleteAllForUser(r.Context(), data.ScopeAuthentication, user.ID)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"message": "your password was successfully reset",
+	}
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+}
+
 func (a *appDependencies) getUser(w http.ResponseWriter, r *http.Request) {
 
 	id, err := a.readIDParam(r)
@@ -156,7 +258,7 @@ func (a *appDependencies) getUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := a.userModel.GetUserProfile(id)
+	user, err := a.userModel.GetUserProfile(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -186,7 +288,20 @@ func (a *appDependencies) getUserLists(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	readList, err := a.userModel.GetUserLists(id)
+	v := validator.New()
+
+	format := a.exportFormat(r, v)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if format != "" {
+		a.exportUserLists(w, r, format, id)
+		return
+	}
+
+	readList, err := a.userModel.GetUserLists(r.Context(), id, a.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -216,7 +331,20 @@ func (a *appDependencies) GetUserReviews(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	reviews, err := a.userModel.GetUserReviews(id)
+	v := validator.New()
+
+	format := a.exportFormat(r, v)
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if format != "" {
+		a.exportUserReviews(w, r, format, id)
+		return
+	}
+
+	reviews, err := a.userModel.GetUserReviews(r.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -237,3 +365,59 @@ func (a *appDependencies) GetUserReviews(w http.ResponseWriter, r *http.Request)
 		return
 	}
 }
+
+func (a *appDependencies) putUserRole(w http.ResponseWriter, r *http.Request) {
+
+	id, err := a.readIDParam(r)
+	if err != nil {
+		a.notFoundResponse(w, r)
+		return
+	}
+
+	var incomingData struct {
+		Role string `json:"role"`
+	}
+
+	err = a.readJSON(w, r, &incomingData)
+	if err != nil {
+		a.badRequestResponse(w, r, err)
+		return
+	}
+
+	permissions, ok := data.Roles[incomingData.Role]
+
+	v := validator.New()
+	v.Check(ok, "role", "must be admin, librarian or member")
+	if !v.IsEmpty() {
+		a.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := a.userModel.GetUserProfile(r.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			a.notFoundResponse(w, r)
+		default:
+			a.serverErrResponse(w, r, err)
+		}
+		return
+	}
+
+	err = a.permissionModel.SetForUser(r.Context(), user.ID, permissions...)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+
+	data := envelope{
+		"user":        user,
+		"permissions": permissions,
+	}
+
+	err = a.writeJSON(w, http.StatusOK, data, nil)
+	if err != nil {
+		a.serverErrResponse(w, r, err)
+		return
+	}
+}
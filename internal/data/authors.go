@@ -0,0 +1,287 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Jcastel2014/test3/internal/validator"
+)
+
+var AuthorRoles = []string{"author", "translator", "editor"}
+
+type Author struct {
+	ID           int64           `json:"id"`
+	Name         string          `json:"name"`
+	Role         string          `json:"role,omitempty"`
+	Bibliography []*Bibliography `json:"bibliography,omitempty"`
+}
+
+// a book an author worked on, and what they did on it
+type Bibliography struct {
+	BookID           int64     `json:"book_id"`
+	Title            string    `json:"title"`
+	ISBN             string    `json:"isbn"`
+	Publication_Date time.Time `json:"created_at"`
+	Role             string    `json:"role"`
+}
+
+// authorList reads the json_agg of a book's authors straight into the slice
+type authorList []Author
+
+func (a *authorList) Scan(src any) error {
+	switch value := src.(type) {
+	case []byte:
+		return json.Unmarshal(value, a)
+	case string:
+		return json.Unmarshal([]byte(value), a)
+	default:
+		return fmt.Errorf("cannot scan %T into authors", src)
+	}
+}
+
+// NormalizeAuthors trims the names and fills in the default role
+func NormalizeAuthors(authors []Author) []Author {
+	for i := range authors {
+		authors[i].Name = strings.TrimSpace(authors[i].Name)
+		if authors[i].Role == "" {
+			authors[i].Role = "author"
+		}
+	}
+
+	return authors
+}
+
+// upsertAuthor returns the id of the author with this name, creating them if
+// needed. Names are matched case-insensitively so "j.k. rowling" and
+// "J.K. Rowling" end up as the same author.
+func upsertAuthor(ctx context.Context, q querier, name string) (int64, error) {
+	query := `
+	INSERT INTO authors (name)
+	VALUES ($1)
+	ON CONFLICT ((lower(name))) DO UPDATE SET name = authors.name
+	RETURNING id
+	`
+
+	var id int64
+
+	err := q.QueryRowContext(ctx, query, name).Scan(&id)
+
+	return id, err
+}
+
+var authorSortColumns = map[string]string{
+	"id":   "A.id",
+	"name": "A.name",
+}
+
+func (b BookClub) GetAllAuthors(ctx context.Context, name string, filters Filters) ([]*Author, Metadata, error) {
+	sortExpression, err := filters.sortExpression(authorSortColumns)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	seek, seekArgs, err := filters.seek(sortExpression, "A.id", 4)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), A.id, A.name, (%s)::text
+	FROM authors AS A
+	WHERE (A.name ILIKE '%%' || $1 || '%%' OR $1 = '') AND %s
+	ORDER BY %s %s, A.id %s
+	LIMIT $2 OFFSET $3
+	`, sortExpression, seek, sortExpression, filters.sortDirection(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	args := append([]any{name, filters.limit(), filters.offset()}, seekArgs...)
+
+	rows, err := b.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	cursorValue := ""
+	authors := []*Author{}
+
+	for rows.Next() {
+		var author Author
+		err := rows.Scan(&totalRecords, &author.ID, &author.Name, &cursorValue)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		authors = append(authors, &author)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	nextCursor := ""
+	if len(authors) > 0 {
+		nextCursor = filters.nextCursor(len(authors), cursorValue, authors[len(authors)-1].ID)
+	}
+
+	return authors, filters.metadata(totalRecords, nextCursor), nil
+}
+
+func (b BookClub) GetAuthor(ctx context.Context, id int64) (*Author, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, name
+	FROM authors
+	WHERE id = $1
+	`
+
+	var author Author
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	err := b.DB.QueryRowContext(ctx, query, id).Scan(&author.ID, &author.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	query = `
+	SELECT B.id, B.title, B.isbn, B.publication_date, BA.role
+	FROM books AS B
+	INNER JOIN book_authors AS BA
+	ON B.id = BA.book_id
+	WHERE BA.author_id = $1
+	ORDER BY B.publication_date ASC, B.id ASC
+	`
+
+	rows, err := b.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	author.Bibliography = []*Bibliography{}
+
+	for rows.Next() {
+		var entry Bibliography
+		err := rows.Scan(&entry.BookID, &entry.Title, &entry.ISBN, &entry.Publication_Date, &entry.Role)
+		if err != nil {
+			return nil, err
+		}
+
+		author.Bibliography = append(author.Bibliography, &entry)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return &author, nil
+}
+
+func (b BookClub) InsertAuthor(ctx context.Context, author *Author) error {
+	query := `
+	INSERT INTO authors (name)
+	VALUES ($1)
+	RETURNING id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	err := b.DB.QueryRowContext(ctx, query, author.Name).Scan(&author.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "authors_name_key"`:
+			return ErrDuplicateAuthor
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (b BookClub) UpdateAuthor(ctx context.Context, author *Author) error {
+	query := `
+	UPDATE authors
+	SET name = $1
+	WHERE id = $2
+	RETURNING id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	err := b.DB.QueryRowContext(ctx, query, author.Name, author.ID).Scan(&author.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "authors_name_key"`:
+			return ErrDuplicateAuthor
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteAuthor refuses to remove an author who is still credited on a book,
+// since that would silently strip the credit from the book
+func (b BookClub) DeleteAuthor(ctx context.Context, id int64) error {
+	query := `
+	DELETE FROM authors
+	WHERE id = $1
+	AND NOT EXISTS (SELECT 1 FROM book_authors WHERE author_id = $1)
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	result, err := b.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		_, err := b.GetAuthor(ctx, id)
+		if err != nil {
+			return err
+		}
+		return ErrAuthorHasBooks
+	}
+
+	return nil
+}
+
+func ValidateAuthor(v *validator.Validator, author *Author) {
+	v.Check(author.Name != "", "name", "must be provided")
+	v.Check(len(author.Name) <= 100, "name", "must not be more than 100 characters long")
+}
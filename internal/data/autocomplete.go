@@ -0,0 +1,81 @@
+package data
+
+import (
+	"context"
+	"strings"
+
+	"github.com/Jcastel2014/test3/internal/validator"
+)
+
+type Suggestion struct {
+	Type  string  `json:"type"`
+	ID    int64   `json:"id"`
+	Text  string  `json:"text"`
+	Score float64 `json:"score"`
+}
+
+// Autocomplete matches what has been typed so far against book titles and
+// author names. word_similarity scores q against the best matching stretch
+// of the title so a partial or misspelt word still finds it, and both the
+// <% operator and the prefix ILIKE are served by the trigram indexes.
+// The prefix pattern is escaped so % and _ typed by the user match literally.
+func (b BookClub) Autocomplete(ctx context.Context, q string, limit int) ([]*Suggestion, error) {
+	query := `
+	SELECT type, id, text, score
+	FROM (
+		SELECT 'book' AS type, id, title AS text, word_similarity($1, title) AS score
+		FROM books
+		WHERE $1 <% title OR title ILIKE $3
+		UNION ALL
+		SELECT 'author', id, name, word_similarity($1, name)
+		FROM authors
+		WHERE $1 <% name OR name ILIKE $3
+	) AS suggestions
+	ORDER BY score DESC, length(text) ASC, id ASC
+	LIMIT $2
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	rows, err := b.DB.QueryContext(ctx, query, q, limit, escapeLike(q)+"%")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	suggestions := []*Suggestion{}
+
+	for rows.Next() {
+		var suggestion Suggestion
+		err := rows.Scan(&suggestion.Type, &suggestion.ID, &suggestion.Text, &suggestion.Score)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
+// likeEscaper escapes the characters LIKE treats specially, with backslash
+// being the default escape character.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+func ValidateAutocomplete(v *validator.Validator, q string, limit int) {
+	v.Check(len(q) >= 2, "q", "must be at least 2 characters long")
+	v.Check(len(q) <= 100, "q", "must not be more than 100 characters long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 25, "limit", "must be a maximum of 25")
+}
@@ -0,0 +1,27 @@
+package data
+
+import "testing"
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"plain", "dune", "dune"},
+		{"percent", "%", `\%`},
+		{"underscore", "a_b", `a\_b`},
+		{"backslash", `a\b`, `a\\b`},
+		{"all together", `50%_\`, `50\%\_\\`},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := escapeLike(tt.input)
+			if got != tt.want {
+				t.Errorf("escapeLike(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
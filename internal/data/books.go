@@ -5,49 +5,183 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/Jcastel2014/test3/internal/validator"
 )
 
 type Book struct {
 	ID               int64     `json:"id"`
 	Title            string    `json:"title"`
 	ISBN             string    `json:"isbn"`
-	Author           string    `json:"author"`
+	Authors          []Author  `json:"authors"`
 	Genre            string    `json:"genre"`
 	Description      string    `json:"description"`
 	Publication_Date time.Time `json:"created_at"`
 	Average_rating   float64   `json:"average_rating"`
+	CoverURL         string    `json:"cover_url,omitempty"`
+	ThumbnailURL     string    `json:"thumbnail_url,omitempty"`
+	Version          int       `json:"version"`
+	Updated_at       time.Time `json:"updated_at"`
 }
 
-func (b BookClub) GetAllBooks(filters Filters) ([]*Book, error) {
-	query := fmt.Sprintf(`
-	SELECT B.id, B.title, B.isbn, A.name AS author, B.publication_date, B.genre, B.description, B.average_rating
+// Cover is where a book's cover image and its thumbnail are kept in storage
+type Cover struct {
+	Key          string
+	ThumbnailKey string
+	UpdatedAt    time.Time
+}
+
+// every book query aggregates the authors so that a book with several of
+// them still comes back as a single row. Queries add their WHERE clause and
+// then GROUP BY B.id.
+const bookColumns = `
+	B.id, B.title, B.isbn, B.publication_date, B.genre, B.description,
+	COALESCE(B.average_rating, 0) AS average_rating, B.version, B.updated_at,
+	COALESCE(json_agg(json_build_object('id', A.id, 'name', A.name, 'role', BA.role) ORDER BY BA.id)
+		FILTER (WHERE A.id IS NOT NULL), '[]') AS authors,
+	` + coverURLs + `
+	`
+
+// the cover URLs carry the upload time so a new cover gets a new URL and the
+// old one can be cached for good
+const coverURLs = `
+	CASE WHEN B.cover_key IS NULL THEN ''
+		ELSE '/api/v1/books/' || B.id || '/cover?v=' || extract(epoch FROM B.cover_updated_at)::bigint
+	END AS cover_url,
+	CASE WHEN B.cover_thumbnail_key IS NULL THEN ''
+		ELSE '/api/v1/books/' || B.id || '/cover?size=thumbnail&v=' || extract(epoch FROM B.cover_updated_at)::bigint
+	END AS thumbnail_url`
+
+const bookJoins = `
 	FROM books AS B
-	INNER JOIN book_authors AS BA 
+	LEFT JOIN book_authors AS BA
 	ON B.id = BA.book_id
-	INNER JOIN authors AS A 
+	LEFT JOIN authors AS A
 	ON A.id = BA.author_id
-	ORDER BY %s %s, B.id ASC
+	`
+
+const bookSelect = `SELECT ` + bookColumns + bookJoins
+
+// bookFields lines up with the columns of bookSelect for rows.Scan
+func bookFields(book *Book) []any {
+	return []any{&book.ID, &book.Title, &book.ISBN, &book.Publication_Date, &book.Genre, &book.Description, &book.Average_rating, &book.Version, &book.Updated_at, (*authorList)(&book.Authors), &book.CoverURL, &book.ThumbnailURL}
+}
+
+// the expression behind each sort key that GetAllBooks accepts
+var bookSortColumns = map[string]string{
+	"id":               "B.id",
+	"title":            "B.title",
+	"publication_date": "COALESCE(B.publication_date, '-infinity')",
+	"average_rating":   "COALESCE(B.average_rating, 0)",
+}
+
+// BookQuery narrows down the books listing, zero values are ignored
+type BookQuery struct {
+	Genre           string
+	Author          string
+	ISBN            string
+	PublishedAfter  *time.Time
+	PublishedBefore *time.Time
+	MinRating       *float64
+	MaxRating       *float64
+}
+
+// where builds the conditions for the filters that were set, numbering the
+// placeholders from argPosition
+func (q BookQuery) where(argPosition int) (string, []any) {
+	conditions := []string{"TRUE"}
+	args := []any{}
+
+	add := func(condition string, arg any) {
+		conditions = append(conditions, fmt.Sprintf(condition, argPosition+len(args)))
+		args = append(args, arg)
+	}
+
+	if q.Genre != "" {
+		add("B.genre ILIKE $%d", q.Genre)
+	}
+
+	if q.Author != "" {
+		add(`EXISTS (
+			SELECT 1
+			FROM book_authors AS FBA
+			INNER JOIN authors AS FA
+			ON FA.id = FBA.author_id
+			WHERE FBA.book_id = B.id
+			AND FA.name ILIKE '%%' || $%d || '%%'
+		)`, q.Author)
+	}
+
+	if q.ISBN != "" {
+		add("B.isbn = $%d", q.ISBN)
+	}
+
+	if q.PublishedAfter != nil {
+		add("B.publication_date >= $%d", *q.PublishedAfter)
+	}
+
+	if q.PublishedBefore != nil {
+		add("B.publication_date <= $%d", *q.PublishedBefore)
+	}
+
+	if q.MinRating != nil {
+		add("COALESCE(B.average_rating, 0) >= $%d", *q.MinRating)
+	}
+
+	if q.MaxRating != nil {
+		add("COALESCE(B.average_rating, 0) <= $%d", *q.MaxRating)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+func (b BookClub) GetAllBooks(ctx context.Context, bookQuery BookQuery, filters Filters) ([]*Book, Metadata, error) {
+	sortExpression, err := filters.sortExpression(bookSortColumns)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	args := []any{filters.limit(), filters.offset()}
+
+	where, whereArgs := bookQuery.where(len(args) + 1)
+	args = append(args, whereArgs...)
+
+	seek, seekArgs, err := filters.seek(sortExpression, "B.id", len(args)+1)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	args = append(args, seekArgs...)
+
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), %s, (%s)::text %s
+	WHERE %s
+	AND %s
+	GROUP BY B.id
+	ORDER BY %s %s, B.id %s
 	LIMIT $1 OFFSET $2
-	`, filters.sortColumn(), filters.sortDirection())
+	`, bookColumns, sortExpression, bookJoins, where, seek, sortExpression, filters.sortDirection(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	rows, err := b.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	rows, err := b.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
 	defer rows.Close()
 
+	totalRecords := 0
+	cursorValue := ""
 	books := []*Book{}
 
 	for rows.Next() {
 		var book Book
-		err := rows.Scan(&book.ID, &book.Title, &book.ISBN, &book.Author, &book.Publication_Date, &book.Genre, &book.Description, &book.Average_rating)
+		err := rows.Scan(append(append([]any{&totalRecords}, bookFields(&book)...), &cursorValue)...)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 
 		books = append(books, &book)
@@ -55,83 +189,82 @@ func (b BookClub) GetAllBooks(filters Filters) ([]*Book, error) {
 
 	err = rows.Err()
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
-	return books, nil
-}
+	nextCursor := ""
+	if len(books) > 0 {
+		nextCursor = filters.nextCursor(len(books), cursorValue, books[len(books)-1].ID)
+	}
 
-func (b BookClub) InsertBook(book *Book) error {
+	return books, filters.metadata(totalRecords, nextCursor), nil
+}
 
-	var idA int
+func (b BookClub) InsertBook(ctx context.Context, book *Book) error {
 
 	query := `
-	INSERT INTO authors(name)
-	VALUES ($1)
-	RETURNING id
+	
+	INSERT INTO books (title, isbn, publication_date, genre, description, average_rating) 
+	VALUES ($1, $2, $3, $4, $5, 0) RETURNING id, version, updated_at;
 	
 	`
 
-	err, idA := b.DoesAuthorExists(book.Author)
-
-	if idA == -1 {
-
-		args := []any{book.Author}
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		defer cancel()
+	args := []any{book.Title, book.ISBN, book.Publication_Date, book.Genre, book.Description}
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
 
-		err = b.DB.QueryRowContext(ctx, query, args...).Scan(&idA)
+	return b.withTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.Version, &book.Updated_at)
 
 		if err != nil {
-			return err
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "books_isbn_key"`:
+				return ErrDuplicateISBN
+			default:
+				return err
+			}
 		}
 
-	}
+		return setBookAuthors(ctx, tx, book)
+	})
 
-	// args := []any{book.Author}
-	// ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	// defer cancel()
+}
 
-	// err = b.DB.QueryRowContext(ctx, query, args...).Scan(&idA)
+// setBookAuthors replaces whoever is credited on the book with book.Authors,
+// creating any authors that don't exist yet
+func setBookAuthors(ctx context.Context, q querier, book *Book) error {
 
-	query = `
-	
-	INSERT INTO books (title, isbn, publication_date, genre, description, average_rating) 
-	VALUES ($1, $2, $3, $4, $5, 0) RETURNING id;
-	
+	query := `
+	DELETE FROM book_authors
+	WHERE book_id = $1
 	`
 
-	var idB int
-	args := []any{book.Title, book.ISBN, book.Publication_Date, book.Genre, book.Description}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err = b.DB.QueryRowContext(ctx, query, args...).Scan(&idB)
-
+	_, err := q.ExecContext(ctx, query, book.ID)
 	if err != nil {
 		return err
 	}
 
 	query = `
-		INSERT INTO book_authors (book_id, author_id) 
-		VALUES ($1, $2) RETURNING id;
-
+	INSERT INTO book_authors (book_id, author_id, role)
+	VALUES ($1, $2, $3)
+	ON CONFLICT DO NOTHING
 	`
 
-	args = []any{idB, idA}
-	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	for i := range book.Authors {
+		book.Authors[i].ID, err = upsertAuthor(ctx, q, book.Authors[i].Name)
+		if err != nil {
+			return err
+		}
 
-	err = b.DB.QueryRowContext(ctx, query, args...).Scan(&idB)
+		args := []any{book.ID, book.Authors[i].ID, book.Authors[i].Role}
 
-	if err != nil {
-		return err
+		_, err = q.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
 	}
 
-	return err
-
-	// return b.UpdateAverage(id)
-
+	return nil
 }
 
 // func (p ProductModel) UpdateAverage(pid int64) error {
@@ -151,29 +284,24 @@ func (b BookClub) InsertBook(book *Book) error {
 // 	return p.DB.QueryRowContext(ctx, query, args...).Scan(&pid)
 // }
 
-func (b BookClub) GetBook(id int64) (*Book, error) {
+func (b BookClub) GetBook(ctx context.Context, id int64) (*Book, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `
-	SELECT B.id, B.title, B.isbn, A.name AS author, B.publication_date, B.genre, B.description, B.average_rating
-	FROM books AS B
-	INNER JOIN book_authors AS BA 
-	ON B.id = BA.book_id
-	INNER JOIN authors AS A 
-	ON A.id = BA.author_id
+	query := fmt.Sprintf(`
+	%s
 	WHERE B.id = $1
-
-	`
+	GROUP BY B.id
+	`, bookSelect)
 
 	args := []any{id}
 
 	var book Book
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	err := b.DB.QueryRowContext(ctx, query, args...).Scan(&book.ID, &book.Title, &book.ISBN, &book.Author, &book.Publication_Date, &book.Genre, &book.Description, &book.Average_rating)
+	err := b.DB.QueryRowContext(ctx, query, args...).Scan(bookFields(&book)...)
 
 	if err != nil {
 		switch {
@@ -187,149 +315,174 @@ func (b BookClub) GetBook(id int64) (*Book, error) {
 
 }
 
-func (b BookClub) UpdateBook(book *Book, id int64) error {
+// GetBookByISBN looks a book up by its canonical ISBN-13
+func (b BookClub) GetBookByISBN(ctx context.Context, isbn string) (*Book, error) {
+	query := fmt.Sprintf(`
+	%s
+	WHERE B.isbn = $1
+	GROUP BY B.id
+	`, bookSelect)
 
-	var idA int
+	var book Book
 
-	query := `
-	INSERT INTO authors(name)
-	VALUES ($1)
-	RETURNING id
-	
-	`
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
 
-	err, idA := b.DoesAuthorExists(book.Author)
+	err := b.DB.QueryRowContext(ctx, query, isbn).Scan(bookFields(&book)...)
 
 	if err != nil {
-		return err
-	}
-
-	if idA == -1 {
-
-		args := []any{book.Author}
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		defer cancel()
-
-		err = b.DB.QueryRowContext(ctx, query, args...).Scan(&idA)
-
-		if err != nil {
-			return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
 		}
-
 	}
+	return &book, nil
+}
 
-	query = `
-	UPDATE book_authors
-	SET author_id = $1
-	WHERE book_id = $2
-	RETURNING book_id
-
-
-	`
-
-	args := []any{idA, id}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	err = b.DB.QueryRowContext(ctx, query, args...).Scan(&book.ID)
-
-	if err != nil {
-		return err
-	}
+func (b BookClub) UpdateBook(ctx context.Context, book *Book, id int64) error {
 
-	query = `
+	query := `
 	UPDATE books
-	SET title = $1, isbn = $2, publication_date = $3, genre = $4, description = $5
-	WHERE id = $6
-	RETURNING id
-
-
+	SET title = $1, isbn = $2, publication_date = $3, genre = $4, description = $5,
+	version = version + 1
+	WHERE id = $6 AND version = $7
+	RETURNING version, updated_at
 	`
 
-	args = []any{book.Title, book.ISBN, book.Publication_Date, book.Genre, book.Description, id}
-	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	args := []any{book.Title, book.ISBN, book.Publication_Date, book.Genre, book.Description, id, book.Version}
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	err = b.DB.QueryRowContext(ctx, query, args...).Scan(&book.ID)
+	return b.withTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&book.Version, &book.Updated_at)
 
-	if err != nil {
-
-		return err
-	}
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "books_isbn_key"`:
+				return ErrDuplicateISBN
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
 
-	return nil
-	// return p.UpdateAverage(review.ID)
+		return setBookAuthors(ctx, tx, book)
+	})
 
 }
 
-func (b BookClub) DeleteBook(id int64) error {
+// DeleteBook returns the cover the book had so its files can be removed
+func (b BookClub) DeleteBook(ctx context.Context, id int64) (*Cover, error) {
 
 	query := `
 	DELETE FROM books
 	WHERE id = $1
+	RETURNING COALESCE(cover_key, ''), COALESCE(cover_thumbnail_key, '')
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	result, err := b.DB.ExecContext(ctx, query, id)
-	if err != nil {
-		return err
-	}
+	var cover Cover
 
-	rowsAffected, err := result.RowsAffected()
+	err := b.DB.QueryRowContext(ctx, query, id).Scan(&cover.Key, &cover.ThumbnailKey)
 	if err != nil {
-		return err
-	}
-
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
 	}
 
-	return nil
+	return &cover, nil
 
 	// return p.UpdateAverage(id)
 
 }
 
-func (b BookClub) SearchBook(title string, author string, genre string) ([]*Book, error) {
+// the facets a books listing can ask for, each one groups the filtered
+// books by a single value
+var BookFacetNames = []string{"genre", "decade", "author", "rating"}
+
+var bookFacetQueries = map[string]string{
+	"genre": `
+	SELECT 'genre', genre, count(*)
+	FROM filtered
+	WHERE genre IS NOT NULL AND genre <> ''
+	GROUP BY genre`,
+	"decade": `
+	SELECT 'decade', (floor(date_part('year', publication_date) / 10) * 10)::int::text || 's', count(*)
+	FROM filtered
+	WHERE publication_date IS NOT NULL
+	GROUP BY 2`,
+	"author": `
+	SELECT 'author', A.name, count(DISTINCT filtered.id)
+	FROM filtered
+	INNER JOIN book_authors AS BA ON BA.book_id = filtered.id
+	INNER JOIN authors AS A ON A.id = BA.author_id
+	GROUP BY A.name`,
+	"rating": `
+	SELECT 'rating', CASE
+		WHEN average_rating IS NULL THEN 'unrated'
+		ELSE (LEAST(floor(average_rating / 2), 4) * 2)::int::text || '-' || (LEAST(floor(average_rating / 2), 4) * 2 + 2)::int::text
+	END, count(*)
+	FROM filtered
+	GROUP BY 2`,
+}
 
-	query := `
-        SELECT B.id, B.title, B.isbn, A.name AS author, B.publication_date, B.genre, B.description, B.average_rating
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// BookFacets counts the books matching bookQuery under each of the requested
+// facets. Pagination doesn't apply, the counts cover every matching book.
+func (b BookClub) BookFacets(ctx context.Context, bookQuery BookQuery, facets []string) (map[string][]*FacetCount, error) {
+	where, args := bookQuery.where(1)
+
+	parts := []string{}
+	for _, facet := range facets {
+		parts = append(parts, bookFacetQueries[facet])
+	}
+
+	query := fmt.Sprintf(`
+	WITH filtered AS (
+		SELECT B.id, B.genre, B.publication_date, B.average_rating
 		FROM books AS B
-		INNER JOIN book_authors AS BA 
-		ON B.id = BA.book_id
-		INNER JOIN authors AS A 
-		ON A.id = BA.author_id
-        WHERE (to_tsvector('simple', B.title) @@
-              plainto_tsquery('simple', $1) OR $1 = '') 
-        AND (to_tsvector('simple', author) @@ 
-             plainto_tsquery('simple', $2) OR $2 = '') 
-		AND (to_tsvector('simple', B.genre) @@ 
-             plainto_tsquery('simple', $3) OR $2 = '') 
-        ORDER BY id  
-     `
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		WHERE %s
+	)
+	SELECT facet, value, total FROM (%s) AS facets (facet, value, total)
+	ORDER BY facet, total DESC, value ASC
+	`, where, strings.Join(parts, "\n\tUNION ALL"))
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	rows, err := b.DB.QueryContext(ctx, query, title, author, genre)
+	rows, err := b.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
 
-	books := []*Book{}
+	result := make(map[string][]*FacetCount, len(facets))
+	for _, facet := range facets {
+		result[facet] = []*FacetCount{}
+	}
 
 	for rows.Next() {
-		var book Book
-		err := rows.Scan(&book.ID, &book.Title, &book.ISBN, &book.Author, &book.Publication_Date, &book.Genre, &book.Description, &book.Average_rating)
+		var facet string
+		var count FacetCount
+		err := rows.Scan(&facet, &count.Value, &count.Count)
 		if err != nil {
 			return nil, err
 		}
 
-		books = append(books, &book)
+		result[facet] = append(result[facet], &count)
 	}
 
 	err = rows.Err()
@@ -337,8 +490,35 @@ func (b BookClub) SearchBook(title string, author string, genre string) ([]*Book
 		return nil, err
 	}
 
-	return books, nil
+	return result, nil
+}
 
+func ValidateBookFacets(v *validator.Validator, facets []string) {
+	for _, facet := range facets {
+		v.Check(validator.PermittedValue(facet, BookFacetNames...), "facets", "must be one of genre, decade, author or rating")
+	}
+	v.Check(validator.Unique(facets), "facets", "must not contain duplicate values")
+}
+
+func ValidateBookQuery(v *validator.Validator, q BookQuery) {
+	v.Check(len(q.Genre) <= 50, "genre", "must not be more than 50 characters long")
+	v.Check(len(q.Author) <= 100, "author", "must not be more than 100 characters long")
+
+	if q.PublishedAfter != nil && q.PublishedBefore != nil {
+		v.Check(!q.PublishedAfter.After(*q.PublishedBefore), "published_after", "must not be later than published_before")
+	}
+
+	if q.MinRating != nil {
+		v.Check(*q.MinRating >= 0 && *q.MinRating <= 10, "min_rating", "must be between 0 and 10")
+	}
+
+	if q.MaxRating != nil {
+		v.Check(*q.MaxRating >= 0 && *q.MaxRating <= 10, "max_rating", "must be between 0 and 10")
+	}
+
+	if q.MinRating != nil && q.MaxRating != nil {
+		v.Check(*q.MinRating <= *q.MaxRating, "min_rating", "must not be greater than max_rating")
+	}
 }
 
 // func (p ProductModel) DoesProductExists(id int64) error {
@@ -366,3 +546,86 @@ func (b BookClub) SearchBook(title string, author string, genre string) ([]*Book
 
 // 	return nil
 // }
+
+func (b BookClub) GetBookCover(ctx context.Context, id int64) (*Cover, error) {
+	query := `
+	SELECT cover_key, COALESCE(cover_thumbnail_key, ''), cover_updated_at
+	FROM books
+	WHERE id = $1 AND cover_key IS NOT NULL
+	`
+
+	var cover Cover
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	err := b.DB.QueryRowContext(ctx, query, id).Scan(&cover.Key, &cover.ThumbnailKey, &cover.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &cover, nil
+}
+
+// SetBookCover points the book at a newly stored cover and hands back the
+// one it replaced so its files can be removed
+func (b BookClub) SetBookCover(ctx context.Context, id int64, key string) (*Cover, error) {
+	query := `
+	UPDATE books AS B
+	SET cover_key = $1, cover_thumbnail_key = NULL, cover_updated_at = NOW()
+	FROM (SELECT id, cover_key, cover_thumbnail_key FROM books WHERE id = $2 FOR UPDATE) AS old
+	WHERE B.id = old.id
+	RETURNING COALESCE(old.cover_key, ''), COALESCE(old.cover_thumbnail_key, '')
+	`
+
+	var previous Cover
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	err := b.DB.QueryRowContext(ctx, query, key, id).Scan(&previous.Key, &previous.ThumbnailKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &previous, nil
+}
+
+// SetBookThumbnail records the thumbnail made for key. If the cover has been
+// replaced in the meantime nothing changes and ErrEditConflict is returned.
+func (b BookClub) SetBookThumbnail(ctx context.Context, id int64, key string, thumbnailKey string) error {
+	query := `
+	UPDATE books
+	SET cover_thumbnail_key = $1
+	WHERE id = $2 AND cover_key = $3
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	result, err := b.DB.ExecContext(ctx, query, thumbnailKey, id, key)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
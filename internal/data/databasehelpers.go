@@ -2,43 +2,77 @@ package data
 
 import (
 	"context"
-	"log"
+	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/Jcastel2014/test3/internal/validator"
 )
 
-func (b BookClub) GetAllById(id int64) ([]*Book, error) {
+// querier is satisfied by both *sql.DB and *sql.Tx so that helpers can run
+// inside or outside of a transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
 
-	query := `
-	
-	SELECT B.id, B.title, B.isbn, A.name AS author, B.publication_date, B.genre, B.description, B.average_rating
-	FROM books AS B
-	INNER JOIN book_authors AS BA 
-	ON B.id = BA.book_id
-	INNER JOIN authors AS A 
-	ON A.id = BA.author_id
-	INNER JOIN book_list AS BL
-	ON BL.book_id = B.id
-	WHERE BL.list_id = $1
-	
-	`
+// withTx runs fn inside a transaction. Everything fn did is committed if it
+// returns nil and rolled back otherwise, so multi-table writes either all
+// happen or none do.
+func (b BookClub) withTx(ctx context.Context, fn func(*sql.Tx) error) error {
+	return runTx(ctx, b.DB, fn)
+}
+
+// runTx is withTx for the models that aren't BookClub
+func runTx(ctx context.Context, db *sql.DB, fn func(*sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (b BookClub) GetAllById(ctx context.Context, id int64) ([]*ListBook, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	rows, err := b.DB.QueryContext(ctx, query, id)
+	return getBooksInList(ctx, b.DB, id)
+}
+
+// getBooksInList is shared by BookClub and UserModel, which both need the
+// books of a reading list
+func getBooksInList(ctx context.Context, q querier, id int64) ([]*ListBook, error) {
+
+	query := fmt.Sprintf(`
+	%s
+	WHERE BL.list_id = $1
+	GROUP BY B.id, BL.id
+	ORDER BY BL.position ASC
+	`, listBookSelect)
+
+	rows, err := q.QueryContext(ctx, query, id)
 	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
 
-	books := []*Book{}
+	books := []*ListBook{}
 
 	for rows.Next() {
-		var book Book
-		err := rows.Scan(&book.ID, &book.Title, &book.ISBN, &book.Author, &book.Publication_Date, &book.Genre, &book.Description, &book.Average_rating)
+		var book ListBook
+		err := rows.Scan(listBookFields(&book)...)
 		if err != nil {
 			return nil, err
 		}
@@ -55,76 +89,104 @@ func (b BookClub) GetAllById(id int64) ([]*Book, error) {
 
 }
 
-func (b BookClub) DoesAuthorExists(author string) (error, int) {
+func (b BookClub) DoesBookExists(ctx context.Context, id int64) error {
 	query := `
 		SELECT id
-		FROM authors
-		WHERE name = $1
+		FROM books
+		WHERE id = $1
 	`
-	args := []any{author}
+	args := []any{id}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	var id int
-
-	err := b.DB.QueryRowContext(ctx, query, args...).Scan(&id)
-
-	log.Println(id)
-
-	if err != nil {
-		return err, -1
-	}
+	return b.DB.QueryRowContext(ctx, query, args...).Scan(&id)
 
-	return nil, id
 }
 
-func (b BookClub) DoesBookExists(id int64) error {
+func (b BookClub) DoesListExists(ctx context.Context, id int64) error {
 	query := `
 		SELECT id
-		FROM books
+		FROM readList
 		WHERE id = $1
 	`
 	args := []any{id}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
 	return b.DB.QueryRowContext(ctx, query, args...).Scan(&id)
 
 }
 
-func (b BookClub) DoesListExists(id int64) error {
+func (b BookClub) DoesUserExists(ctx context.Context, id int64) error {
 	query := `
 		SELECT id
-		FROM readList
+		FROM users
 		WHERE id = $1
 	`
 	args := []any{id}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
 	return b.DB.QueryRowContext(ctx, query, args...).Scan(&id)
 
 }
 
-func (b BookClub) DoesUserExists(id int64) error {
+// GetListOwner reports a list that viewer isn't allowed to see as not found,
+// the same as GetList, so a private list's id can't be probed
+func (b BookClub) GetListOwner(ctx context.Context, id int64, viewer int64) (int64, error) {
+	query := fmt.Sprintf(`
+		SELECT created_by
+		FROM readList AS R
+		WHERE R.id = $1 AND %s
+	`, listVisibleTo(2))
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	var owner sql.NullInt64
+
+	err := b.DB.QueryRowContext(ctx, query, id, viewer).Scan(&owner)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return owner.Int64, nil
+}
+
+func (b BookClub) GetReviewOwner(ctx context.Context, id int64) (int64, error) {
 	query := `
-		SELECT id
-		FROM users
+		SELECT user_id
+		FROM book_reviews
 		WHERE id = $1
 	`
-	args := []any{id}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	return b.DB.QueryRowContext(ctx, query, args...).Scan(&id)
+	var owner sql.NullInt64
+
+	err := b.DB.QueryRowContext(ctx, query, id).Scan(&owner)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
 
+	return owner.Int64, nil
 }
 
-func (b BookClub) UpdateAverage(id int64) error {
+func updateAverage(ctx context.Context, q querier, id int64) error {
 
 	query := `
 	UPDATE books
@@ -133,10 +195,7 @@ func (b BookClub) UpdateAverage(id int64) error {
 `
 	args := []any{id}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	_, err := b.DB.ExecContext(ctx, query, args...)
+	_, err := q.ExecContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
@@ -149,10 +208,14 @@ func ValidateBook(v *validator.Validator, book *Book) {
 	v.Check(len(book.Title) <= 255, "title", "must not be more than 100 byte long")
 
 	v.Check(book.ISBN != "", "isbn", "must be provided")
-	v.Check(len(book.ISBN) <= 255, "isbn", "must not be more than 100 byte long")
+	v.Check(ValidISBN(book.ISBN), "isbn", "must be a valid ISBN-10 or ISBN-13")
 
-	v.Check(book.Author != "", "author", "must be provided")
-	v.Check(len(book.Author) <= 100, "author", "must not be more than 100 characters long")
+	v.Check(len(book.Authors) > 0, "authors", "must contain at least one author")
+	for _, author := range book.Authors {
+		v.Check(author.Name != "", "authors", "every author must have a name")
+		v.Check(len(author.Name) <= 100, "authors", "author names must not be more than 100 characters long")
+		v.Check(validator.PermittedValue(author.Role, AuthorRoles...), "authors", "role must be author, translator or editor")
+	}
 
 	v.Check(book.Genre != "", "genre", "must be provided")
 	v.Check(len(book.Genre) <= 50, "genre", "must not be more than 50 characters long")
@@ -180,6 +243,8 @@ func ValidateListInt(v *validator.Validator, list *ReadListInt) {
 
 	// v.Check(list.Status == "Completed" || list.Status == "Currently Reading", "status", "must be Completed or Currently Reading")
 
+	v.Check(validator.PermittedValue(list.Visibility, ListVisibilities...), "visibility", "must be public, private or unlisted")
+
 }
 
 func ValidateList(v *validator.Validator, list *ReadList) {
@@ -194,6 +259,24 @@ func ValidateList(v *validator.Validator, list *ReadList) {
 
 	// v.Check(list.Status == "Completed" || list.Status == "Currently Reading", "status", "must be Completed or Currently Reading")
 
+	v.Check(validator.PermittedValue(list.Visibility, ListVisibilities...), "visibility", "must be public, private or unlisted")
+
+}
+
+func ValidateListBook(v *validator.Validator, book *ListBook) {
+	v.Check(validator.PermittedValue(book.Status, EntryStatuses...), "status", "must be want-to-read, reading, finished or abandoned")
+
+	if book.CurrentPage != nil {
+		v.Check(*book.CurrentPage >= 0, "current_page", "must not be negative")
+	}
+
+	if book.Percent != nil {
+		v.Check(*book.Percent >= 0 && *book.Percent <= 100, "percent", "must be between 0 and 100")
+	}
+
+	if book.Started_at != nil && book.Finished_at != nil {
+		v.Check(!book.Finished_at.Before(*book.Started_at), "finished_at", "must not be before started_at")
+	}
 }
 
 func ValidateReview(v *validator.Validator, review *ReviewIn) {
@@ -205,49 +288,9 @@ func ValidateReview(v *validator.Validator, review *ReviewIn) {
 	v.Check(review.Rating <= 10, "rating", "rating must be less than 10")
 }
 
-func (u UserModel) GetAllById(id int64) ([]*Book, error) {
-
-	query := `
-	
-	SELECT B.id, B.title, B.isbn, A.name AS author, B.publication_date, B.genre, B.description, B.average_rating
-	FROM books AS B
-	INNER JOIN book_authors AS BA 
-	ON B.id = BA.book_id
-	INNER JOIN authors AS A 
-	ON A.id = BA.author_id
-	INNER JOIN book_list AS BL
-	ON BL.book_id = B.id
-	WHERE BL.list_id = $1
-	
-	`
-
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (u UserModel) GetAllById(ctx context.Context, id int64) ([]*ListBook, error) {
+	ctx, cancel := context.WithTimeout(ctx, u.QueryTimeout)
 	defer cancel()
 
-	rows, err := u.DB.QueryContext(ctx, query, id)
-	if err != nil {
-		return nil, err
-	}
-
-	defer rows.Close()
-
-	books := []*Book{}
-
-	for rows.Next() {
-		var book Book
-		err := rows.Scan(&book.ID, &book.Title, &book.ISBN, &book.Author, &book.Publication_Date, &book.Genre, &book.Description, &book.Average_rating)
-		if err != nil {
-			return nil, err
-		}
-
-		books = append(books, &book)
-	}
-
-	err = rows.Err()
-	if err != nil {
-		return nil, err
-	}
-
-	return books, nil
-
+	return getBooksInList(ctx, u.DB, id)
 }
@@ -9,3 +9,10 @@ var UserNotFound = errors.New("user not found")
 
 var ErrDuplicateEmail = errors.New("duplicate email")
 var ErrEditConflict = errors.New("edit conflict")
+
+var ErrDuplicateAuthor = errors.New("duplicate author")
+var ErrAuthorHasBooks = errors.New("author has books")
+var ErrDuplicateISBN = errors.New("duplicate isbn")
+var ErrDuplicateListBook = errors.New("book already on list")
+var ErrInvalidOrder = errors.New("invalid order")
+var ErrDuplicateStatus = errors.New("duplicate status")
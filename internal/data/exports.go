@@ -0,0 +1,136 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// exports hand each row to fn as soon as it is scanned so nothing is held in
+// memory. They run under the request's context rather than QueryTimeout,
+// a full catalog can take longer than a normal query to send.
+
+type ListExportRow struct {
+	ListID   int64  `json:"list_id"`
+	ListName string `json:"list_name"`
+	Status   string `json:"status"`
+	Book     *Book  `json:"book"`
+}
+
+type ReviewExportRow struct {
+	ID         int64     `json:"id"`
+	Review     string    `json:"review"`
+	Rating     float64   `json:"rating"`
+	Created_at time.Time `json:"created_at"`
+	Book       *Book     `json:"book"`
+}
+
+func (b BookClub) ExportBooks(ctx context.Context, bookQuery BookQuery, fn func(*Book) error) error {
+	where, args := bookQuery.where(1)
+
+	query := fmt.Sprintf(`
+	SELECT %s %s
+	WHERE %s
+	GROUP BY B.id
+	ORDER BY B.id ASC
+	`, bookColumns, bookJoins, where)
+
+	rows, err := b.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var book Book
+		err := rows.Scan(bookFields(&book)...)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&book)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// ExportUserLists has a row for every book on every one of the user's lists
+// that viewer is allowed to see
+func (u *UserModel) ExportUserLists(ctx context.Context, id int64, viewer int64, fn func(*ListExportRow) error) error {
+	query := fmt.Sprintf(`
+	SELECT R.id, R.name, BL.status, %s
+	FROM readlist AS R
+	INNER JOIN book_list AS BL ON BL.list_id = R.id
+	INNER JOIN books AS B ON B.id = BL.book_id
+	LEFT JOIN book_authors AS BA ON B.id = BA.book_id
+	LEFT JOIN authors AS A ON A.id = BA.author_id
+	WHERE R.created_by = $1 AND %s
+	GROUP BY R.id, BL.id, B.id
+	ORDER BY R.id ASC, BL.position ASC
+	`, bookColumns, listVisibleTo(2))
+
+	rows, err := u.DB.QueryContext(ctx, query, id, viewer)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		row := ListExportRow{Book: &Book{}}
+
+		dest := append([]any{&row.ListID, &row.ListName, &row.Status}, bookFields(row.Book)...)
+		err := rows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func (u *UserModel) ExportUserReviews(ctx context.Context, id int64, fn func(*ReviewExportRow) error) error {
+	query := fmt.Sprintf(`
+	SELECT R.id, R.review, R.rating, R.created_at, %s
+	FROM book_reviews AS R
+	INNER JOIN books AS B ON B.id = R.book_id
+	LEFT JOIN book_authors AS BA ON B.id = BA.book_id
+	LEFT JOIN authors AS A ON A.id = BA.author_id
+	WHERE R.user_id = $1
+	GROUP BY R.id, B.id
+	ORDER BY R.id ASC
+	`, bookColumns)
+
+	rows, err := u.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		row := ReviewExportRow{Book: &Book{}}
+
+		dest := append([]any{&row.ID, &row.Review, &row.Rating, &row.Created_at}, bookFields(row.Book)...)
+		err := rows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
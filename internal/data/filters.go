@@ -1,6 +1,11 @@
 package data
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/Jcastel2014/test3/internal/validator"
@@ -11,14 +16,24 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	Cursor       string
 }
 
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+}
+
+// cursor is what the opaque pagination token decodes to, the sort key and
+// id of the last row the client has already seen
+type cursor struct {
+	Sort  string `json:"sort"`
+	Value string `json:"value"`
+	ID    int64  `json:"id"`
 }
 
 func calculateMetaData(totalRecords int, currentPage int, pageSize int) Metadata {
@@ -37,8 +52,13 @@ func calculateMetaData(totalRecords int, currentPage int, pageSize int) Metadata
 }
 
 func ValidateFilters(v *validator.Validator, f Filters) {
-	v.Check(f.Page > 0, "page", "must be greater than zero")
-	v.Check(f.Page <= 500, "page", "must be a maximum of 500")
+	if f.Cursor != "" {
+		_, err := f.decodeCursor()
+		v.Check(err == nil, "cursor", "must be a next_cursor value returned for the same sort")
+	} else {
+		v.Check(f.Page > 0, "page", "must be greater than zero")
+		v.Check(f.Page <= 500, "page", "must be a maximum of 500, use cursor to go further")
+	}
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
@@ -52,6 +72,9 @@ func (f Filters) limit() int {
 }
 
 func (f Filters) offset() int {
+	if f.Cursor != "" {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
 
@@ -72,3 +95,96 @@ func (f Filters) sortDirection() string {
 	}
 	return "ASC"
 }
+
+// sortExpression is the SQL behind the sort key. The keyset condition lives
+// in the WHERE clause where output column names can't be used, so ORDER BY
+// and seek both work off the same expression.
+func (f Filters) sortExpression(columns map[string]string) (string, error) {
+	expression, ok := columns[f.sortColumn()]
+	if !ok {
+		return "", errors.New("no sort expression for: " + f.Sort)
+	}
+	return expression, nil
+}
+
+func (f Filters) decodeCursor() (cursor, error) {
+	var c cursor
+
+	js, err := base64.RawURLEncoding.DecodeString(f.Cursor)
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(js, &c)
+	if err != nil {
+		return c, err
+	}
+
+	// malformed cursors, with unknown or missing fields, are rejected because
+	// they don't marshal back to the same bytes. The cursor isn't signed, so
+	// a well-formed one built by hand still decodes; it can only move the
+	// client within rows it is already allowed to list.
+	canonical, err := json.Marshal(c)
+	if err != nil {
+		return c, err
+	}
+	if !bytes.Equal(js, canonical) || c.ID < 1 {
+		return c, errors.New("cursor is malformed")
+	}
+
+	if c.Sort != f.Sort {
+		return c, errors.New("cursor was issued for a different sort")
+	}
+
+	return c, nil
+}
+
+// seek is the keyset condition for cursor pagination, starting with
+// placeholder $argPosition. Without a cursor it matches every row.
+func (f Filters) seek(sortExpression string, idExpression string, argPosition int) (string, []any, error) {
+	if f.Cursor == "" {
+		return "TRUE", nil, nil
+	}
+
+	c, err := f.decodeCursor()
+	if err != nil {
+		return "", nil, err
+	}
+
+	operator := ">"
+	if f.sortDirection() == "DESC" {
+		operator = "<"
+	}
+
+	condition := fmt.Sprintf("(%s, %s) %s ($%d, $%d)", sortExpression, idExpression, operator, argPosition, argPosition+1)
+
+	return condition, []any{c.Value, c.ID}, nil
+}
+
+// nextCursor is only handed out when the page came back full, a short page
+// means there is nothing after it
+func (f Filters) nextCursor(rows int, value string, id int64) string {
+	if rows < f.limit() {
+		return ""
+	}
+
+	js, err := json.Marshal(cursor{Sort: f.Sort, Value: value, ID: id})
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// metadata works out the page numbers for offset pagination. Page numbers
+// mean nothing once the client is following a cursor so they are left out.
+func (f Filters) metadata(totalRecords int, nextCursor string) Metadata {
+	if f.Cursor != "" {
+		return Metadata{PageSize: f.PageSize, NextCursor: nextCursor}
+	}
+
+	metadata := calculateMetaData(totalRecords, f.Page, f.PageSize)
+	metadata.NextCursor = nextCursor
+
+	return metadata
+}
@@ -0,0 +1,149 @@
+package data
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+
+	"github.com/Jcastel2014/test3/internal/validator"
+)
+
+func testFilters(sort string, cursor string) Filters {
+	return Filters{
+		Page:         1,
+		PageSize:     2,
+		Sort:         sort,
+		SortSafeList: []string{"id", "title", "-id", "-title"},
+		Cursor:       cursor,
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		sort  string
+		value string
+		id    int64
+		seek  string
+	}{
+		{"ascending", "title", "Dune", 7, "(b.title, b.id) > ($3, $4)"},
+		{"descending", "-title", "Dune", 7, "(b.title, b.id) < ($3, $4)"},
+		{"empty value", "id", "", 1, "(b.title, b.id) > ($3, $4)"},
+		{"value needing escapes", "-id", `"<Emma & Co>" é`, 42, "(b.title, b.id) < ($3, $4)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := testFilters(tt.sort, "").nextCursor(2, tt.value, tt.id)
+			if token == "" {
+				t.Fatal("nextCursor returned no cursor for a full page")
+			}
+
+			f := testFilters(tt.sort, token)
+
+			c, err := f.decodeCursor()
+			if err != nil {
+				t.Fatalf("decodeCursor returned %v", err)
+			}
+
+			want := cursor{Sort: tt.sort, Value: tt.value, ID: tt.id}
+			if c != want {
+				t.Errorf("decodeCursor = %+v, want %+v", c, want)
+			}
+
+			condition, args, err := f.seek("b.title", "b.id", 3)
+			if err != nil {
+				t.Fatalf("seek returned %v", err)
+			}
+			if condition != tt.seek {
+				t.Errorf("seek condition = %q, want %q", condition, tt.seek)
+			}
+			if !reflect.DeepEqual(args, []any{tt.value, tt.id}) {
+				t.Errorf("seek args = %v, want [%v %v]", args, tt.value, tt.id)
+			}
+
+			if f.offset() != 0 {
+				t.Errorf("offset with a cursor = %d, want 0", f.offset())
+			}
+		})
+	}
+}
+
+func TestNextCursorShortPage(t *testing.T) {
+	token := testFilters("title", "").nextCursor(1, "Dune", 7)
+	if token != "" {
+		t.Errorf("nextCursor for a short page = %q, want \"\"", token)
+	}
+}
+
+func TestSeekWithoutCursor(t *testing.T) {
+	condition, args, err := testFilters("title", "").seek("b.title", "b.id", 3)
+	if err != nil || condition != "TRUE" || args != nil {
+		t.Errorf("seek without a cursor = %q, %v, %v, want \"TRUE\", nil, nil", condition, args, err)
+	}
+}
+
+func TestCursorRejected(t *testing.T) {
+	encode := func(js string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(js))
+	}
+
+	valid := testFilters("title", "").nextCursor(2, "Dune", 7)
+
+	tests := []struct {
+		name   string
+		sort   string
+		cursor string
+	}{
+		{"different sort field", "id", valid},
+		{"different sort direction", "-title", valid},
+		{"truncated", "title", valid[:len(valid)-4]},
+		{"padded base64", "title", base64.URLEncoding.EncodeToString([]byte(`{"sort":"title","value":"Dune","id":7}`))},
+		{"not base64", "title", "not a cursor!"},
+		{"garbage", "title", encode("garbage")},
+		{"not an object", "title", encode(`["title","Dune",7]`)},
+		{"wrong type", "title", encode(`{"sort":"title","value":"Dune","id":"7"}`)},
+		{"extra field", "title", encode(`{"sort":"title","value":"Dune","id":7,"admin":true}`)},
+		{"missing id", "title", encode(`{"sort":"title","value":"Dune"}`)},
+		{"zero id", "title", encode(`{"sort":"title","value":"Dune","id":0}`)},
+		{"negative id", "title", encode(`{"sort":"title","value":"Dune","id":-1}`)},
+		{"reordered fields", "title", encode(`{"id":7,"sort":"title","value":"Dune"}`)},
+		{"trailing data", "title", encode(`{"sort":"title","value":"Dune","id":7} {}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := testFilters(tt.sort, tt.cursor)
+
+			_, err := f.decodeCursor()
+			if err == nil {
+				t.Fatal("decodeCursor accepted the cursor")
+			}
+
+			_, _, err = f.seek("b.title", "b.id", 3)
+			if err == nil {
+				t.Error("seek accepted the cursor")
+			}
+
+			v := validator.New()
+			ValidateFilters(v, f)
+			if _, ok := v.Errors["cursor"]; !ok {
+				t.Errorf("ValidateFilters errors = %v, want a cursor error", v.Errors)
+			}
+		})
+	}
+}
+
+func TestSortExpressionUnknownColumn(t *testing.T) {
+	columns := map[string]string{"id": "b.id"}
+
+	expression, err := testFilters("-id", "").sortExpression(columns)
+	if err != nil || expression != "b.id" {
+		t.Errorf("sortExpression = %q, %v, want %q, nil", expression, err, "b.id")
+	}
+
+	_, err = testFilters("title", "").sortExpression(columns)
+	if err == nil {
+		t.Error("sortExpression returned no error for a sort key without a column")
+	}
+}
@@ -0,0 +1,353 @@
+package data
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	ImportPending   = "pending"
+	ImportRunning   = "running"
+	ImportCompleted = "completed"
+	ImportFailed    = "failed"
+)
+
+// what happened to a single row of an import
+const (
+	RowImported  = "imported"
+	RowDuplicate = "duplicate"
+	RowInvalid   = "invalid"
+	RowFailed    = "failed"
+)
+
+// the most rows a single import will take
+const MaxImportRows = 10_000
+
+type ImportJob struct {
+	ID         int64        `json:"id"`
+	UserID     int64        `json:"user_id"`
+	Format     string       `json:"format"`
+	Status     string       `json:"status"`
+	TotalRows  int          `json:"total_rows"`
+	Processed  int          `json:"processed"`
+	Imported   int          `json:"imported"`
+	Duplicates int          `json:"duplicates"`
+	Failed     int          `json:"failed"`
+	Report     []*ImportRow `json:"report"`
+	CreatedAt  time.Time    `json:"created_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+}
+
+// ImportRow is the report line for one row, numbered from 1 after the header
+type ImportRow struct {
+	Row    int               `json:"row"`
+	ISBN   string            `json:"isbn,omitempty"`
+	Title  string            `json:"title,omitempty"`
+	Status string            `json:"status"`
+	BookID int64             `json:"book_id,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// the header names Goodreads and LibraryThing exports use for each field,
+// in order of preference
+var importColumns = map[string][]string{
+	"title":       {"title"},
+	"author":      {"author", "authors", "primary author"},
+	"additional":  {"additional authors", "secondary author"},
+	"isbn":        {"isbn13", "isbn", "isbns"},
+	"genre":       {"genre", "subjects", "tags"},
+	"description": {"description", "summary"},
+	"date":        {"publication_date", "original publication year", "year published", "date"},
+}
+
+// ParseBookCSV reads a Goodreads or LibraryThing export, or any CSV using
+// the same field names as the API. Rows without a genre get defaultGenre.
+func ParseBookCSV(r io.Reader, defaultGenre string) ([]*Book, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("the file is empty")
+		}
+		return nil, err
+	}
+
+	positions := map[string]int{}
+	for i, name := range header {
+		positions[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+
+	// every column present for a field, so a row whose preferred column is
+	// blank (Goodreads leaves ISBN13 empty for older books) falls back to the
+	// next one
+	columns := map[string][]string{}
+	for field, names := range importColumns {
+		for _, name := range names {
+			if _, ok := positions[name]; ok {
+				columns[field] = append(columns[field], name)
+			}
+		}
+	}
+
+	if _, ok := columns["title"]; !ok {
+		return nil, errors.New("the file has no title column")
+	}
+
+	books := []*Book{}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(books) == MaxImportRows {
+			return nil, fmt.Errorf("the file must not have more than %d rows", MaxImportRows)
+		}
+
+		headers := map[string]string{}
+		value := func(field string) string {
+			for _, name := range columns[field] {
+				position := positions[name]
+				if position >= len(record) {
+					continue
+				}
+
+				value := strings.TrimSpace(record[position])
+				if field == "isbn" {
+					value = cleanImportISBN(value)
+				}
+				if value != "" {
+					headers[field] = name
+					return value
+				}
+			}
+			return ""
+		}
+
+		book := &Book{
+			Title:            value("title"),
+			ISBN:             NormalizeISBN(value("isbn")),
+			Genre:            firstImportValue(value("genre")),
+			Description:      value("description"),
+			Publication_Date: parseImportDate(value("date")),
+			Authors:          []Author{},
+		}
+
+		if book.Genre == "" {
+			book.Genre = defaultGenre
+		}
+
+		if name := value("author"); name != "" {
+			// LibraryThing writes its authors as "Last, First"
+			if headers["author"] == "primary author" {
+				name = flipImportName(name)
+			}
+			book.Authors = append(book.Authors, Author{Name: name})
+		}
+
+		for _, name := range splitImportList(value("additional")) {
+			if headers["additional"] == "secondary author" {
+				name = flipImportName(name)
+			}
+			book.Authors = append(book.Authors, Author{Name: name})
+		}
+
+		book.Authors = NormalizeAuthors(book.Authors)
+
+		books = append(books, book)
+	}
+
+	return books, nil
+}
+
+// ParseBookNDJSON reads one book per line in the same shape postBook takes
+func ParseBookNDJSON(r io.Reader) ([]*Book, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	books := []*Book{}
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		if len(books) == MaxImportRows {
+			return nil, fmt.Errorf("the file must not have more than %d rows", MaxImportRows)
+		}
+
+		var row struct {
+			Title            string    `json:"title"`
+			ISBN             string    `json:"isbn"`
+			Authors          []Author  `json:"authors"`
+			Genre            string    `json:"genre"`
+			Description      string    `json:"description"`
+			Publication_Date time.Time `json:"created_at"`
+		}
+
+		err := json.Unmarshal([]byte(text), &row)
+		if err != nil {
+			return nil, fmt.Errorf("line %d contains badly-formed JSON", line)
+		}
+
+		books = append(books, &Book{
+			Title:            row.Title,
+			ISBN:             NormalizeISBN(row.ISBN),
+			Authors:          NormalizeAuthors(row.Authors),
+			Genre:            row.Genre,
+			Description:      row.Description,
+			Publication_Date: row.Publication_Date,
+		})
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+// Goodreads wraps ISBNs as ="0439023483" so spreadsheets keep the leading
+// zero, LibraryThing writes [0439023483] and may list several
+func cleanImportISBN(isbn string) string {
+	isbn = strings.Trim(isbn, `="[] `)
+	return firstImportValue(isbn)
+}
+
+func firstImportValue(value string) string {
+	list := splitImportList(value)
+	if len(list) == 0 {
+		return ""
+	}
+	return list[0]
+}
+
+func splitImportList(value string) []string {
+	separator := ","
+	for _, candidate := range []string{"|", ";"} {
+		if strings.Contains(value, candidate) {
+			separator = candidate
+			break
+		}
+	}
+
+	list := []string{}
+	for _, item := range strings.Split(value, separator) {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func flipImportName(name string) string {
+	last, first, found := strings.Cut(name, ",")
+	if !found {
+		return name
+	}
+	return strings.TrimSpace(first) + " " + strings.TrimSpace(last)
+}
+
+// parseImportDate takes a full date or just a year, anything else is left
+// zero for ValidateBook to report
+func parseImportDate(value string) time.Time {
+	for _, layout := range []string{time.DateOnly, "2006/01/02", time.RFC3339, "2006"} {
+		date, err := time.Parse(layout, value)
+		if err == nil {
+			return date
+		}
+	}
+	return time.Time{}
+}
+
+func (b BookClub) InsertImportJob(ctx context.Context, job *ImportJob) error {
+	query := `
+	INSERT INTO import_jobs (user_id, format, status, total_rows)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	return b.DB.QueryRowContext(ctx, query, job.UserID, job.Format, job.Status, job.TotalRows).Scan(&job.ID, &job.CreatedAt)
+}
+
+func (b BookClub) GetImportJob(ctx context.Context, id int64) (*ImportJob, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT id, COALESCE(user_id, 0), format, status, total_rows, processed, imported, duplicates, failed, report, created_at, finished_at
+	FROM import_jobs
+	WHERE id = $1
+	`
+
+	var job ImportJob
+	var report []byte
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	err := b.DB.QueryRowContext(ctx, query, id).Scan(&job.ID, &job.UserID, &job.Format, &job.Status, &job.TotalRows,
+		&job.Processed, &job.Imported, &job.Duplicates, &job.Failed, &report, &job.CreatedAt, &job.FinishedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(report, &job.Report)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+// UpdateImportJob saves the progress and report so far
+func (b BookClub) UpdateImportJob(ctx context.Context, job *ImportJob) error {
+	report, err := json.Marshal(job.Report)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE import_jobs
+	SET status = $1, processed = $2, imported = $3, duplicates = $4, failed = $5, report = $6, finished_at = $7
+	WHERE id = $8
+	`
+
+	args := []any{job.Status, job.Processed, job.Imported, job.Duplicates, job.Failed, report, job.FinishedAt, job.ID}
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	_, err = b.DB.ExecContext(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,230 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBookCSV(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []*Book
+	}{
+		{
+			name: "goodreads export",
+			input: "Book Id,Title,Author,Additional Authors,ISBN,ISBN13,Original Publication Year\n" +
+				`1,The Hunger Games,Suzanne Collins,,="0439023483",="9780439023481",2008` + "\n",
+			want: []*Book{{
+				Title:            "The Hunger Games",
+				ISBN:             "9780439023481",
+				Genre:            "fiction",
+				Publication_Date: time.Date(2008, 1, 1, 0, 0, 0, 0, time.UTC),
+				Authors:          []Author{{Name: "Suzanne Collins", Role: "author"}},
+			}},
+		},
+		{
+			name: "goodreads falls back to isbn when isbn13 is blank",
+			input: "Title,Author,ISBN,ISBN13\n" +
+				`Harper Lee Novel,Harper Lee,="0061120081",=""` + "\n",
+			want: []*Book{{
+				Title:   "Harper Lee Novel",
+				ISBN:    "9780061120084",
+				Genre:   "fiction",
+				Authors: []Author{{Name: "Harper Lee", Role: "author"}},
+			}},
+		},
+		{
+			name: "librarything export",
+			input: "TITLE,Primary Author,Secondary Author,ISBNs,Subjects,Date\n" +
+				`Dune,"Herbert, Frank","Smith, Jane; Doe, John",[0441172717],Science fiction | Classics,1965` + "\n",
+			want: []*Book{{
+				Title:            "Dune",
+				ISBN:             "9780441172719",
+				Genre:            "Science fiction",
+				Publication_Date: time.Date(1965, 1, 1, 0, 0, 0, 0, time.UTC),
+				Authors: []Author{
+					{Name: "Frank Herbert", Role: "author"},
+					{Name: "Jane Smith", Role: "author"},
+					{Name: "John Doe", Role: "author"},
+				},
+			}},
+		},
+		{
+			name: "api field names with byte order mark",
+			input: "\ufefftitle,author,isbn,genre,description,publication_date\n" +
+				"Emma,Jane Austen,978-0-14-143958-7,romance, A novel ,1815-12-23\n",
+			want: []*Book{{
+				Title:            "Emma",
+				ISBN:             "9780141439587",
+				Genre:            "romance",
+				Description:      "A novel",
+				Publication_Date: time.Date(1815, 12, 23, 0, 0, 0, 0, time.UTC),
+				Authors:          []Author{{Name: "Jane Austen", Role: "author"}},
+			}},
+		},
+		{
+			name:  "blank lines are skipped",
+			input: "title,author\n\nEmma,Jane Austen\n\n",
+			want: []*Book{{
+				Title:   "Emma",
+				Genre:   "fiction",
+				Authors: []Author{{Name: "Jane Austen", Role: "author"}},
+			}},
+		},
+		{
+			name:  "short and empty rows are kept for validation",
+			input: "title,author,date\nEmma\n,,\n",
+			want: []*Book{
+				{Title: "Emma", Genre: "fiction", Authors: []Author{}},
+				{Genre: "fiction", Authors: []Author{}},
+			},
+		},
+		{
+			name:  "stray quotes are kept",
+			input: "title,author\nThe \"Best\" Book,Jane Austen\n",
+			want: []*Book{{
+				Title:   `The "Best" Book`,
+				Genre:   "fiction",
+				Authors: []Author{{Name: "Jane Austen", Role: "author"}},
+			}},
+		},
+		{
+			name:  "unparseable date is left zero",
+			input: "title,date\nEmma,sometime\n",
+			want:  []*Book{{Title: "Emma", Genre: "fiction", Authors: []Author{}}},
+		},
+		{
+			name:  "header only",
+			input: "title,author\n",
+			want:  []*Book{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBookCSV(strings.NewReader(tt.input), "fiction")
+			if err != nil {
+				t.Fatalf("ParseBookCSV returned %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseBookCSV = %s, want %s", describeBooks(got), describeBooks(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseBookCSVErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"empty file", "", "the file is empty"},
+		{"no title column", "author,isbn\nJane Austen,9780141439587\n", "the file has no title column"},
+		{"too many rows", "title\n" + strings.Repeat("Emma\n", MaxImportRows+1), "the file must not have more than 10000 rows"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseBookCSV(strings.NewReader(tt.input), "fiction")
+			if err == nil {
+				t.Fatalf("ParseBookCSV returned no error, want %q", tt.want)
+			}
+
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseBookCSV error = %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseBookNDJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []*Book
+	}{
+		{
+			name: "one book per line",
+			input: `{"title":"Emma","isbn":"0-14-143958-0","authors":[{"name":" Jane Austen "}],"genre":"romance","created_at":"1815-12-23T00:00:00Z"}` + "\n" +
+				`{"title":"Dune","isbn":"9780441172719","authors":[{"name":"Frank Herbert","role":"editor"}]}` + "\n",
+			want: []*Book{
+				{
+					Title:            "Emma",
+					ISBN:             "9780141439587",
+					Genre:            "romance",
+					Publication_Date: time.Date(1815, 12, 23, 0, 0, 0, 0, time.UTC),
+					Authors:          []Author{{Name: "Jane Austen", Role: "author"}},
+				},
+				{
+					Title:   "Dune",
+					ISBN:    "9780441172719",
+					Authors: []Author{{Name: "Frank Herbert", Role: "editor"}},
+				},
+			},
+		},
+		{
+			name:  "blank lines are skipped",
+			input: "\n   \n{\"title\":\"Emma\"}\n\n",
+			want:  []*Book{{Title: "Emma"}},
+		},
+		{
+			name:  "empty file",
+			input: "",
+			want:  []*Book{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBookNDJSON(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("ParseBookNDJSON returned %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseBookNDJSON = %s, want %s", describeBooks(got), describeBooks(tt.want))
+			}
+		})
+	}
+}
+
+func TestParseBookNDJSONErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"malformed first line", "{\"title\":\n", "line 1 contains badly-formed JSON"},
+		{"blank lines still count", "{\"title\":\"Emma\"}\n\n[1, 2]\n", "line 3 contains badly-formed JSON"},
+		{"wrong field type", "{\"title\":\"Emma\"}\n{\"title\":42}\n", "line 2 contains badly-formed JSON"},
+		{"bad date", "{\"title\":\"Emma\",\"created_at\":\"1815\"}\n", "line 1 contains badly-formed JSON"},
+		{"too many rows", strings.Repeat("{\"title\":\"Emma\"}\n", MaxImportRows+1), "the file must not have more than 10000 rows"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseBookNDJSON(strings.NewReader(tt.input))
+			if err == nil {
+				t.Fatalf("ParseBookNDJSON returned no error, want %q", tt.want)
+			}
+
+			if err.Error() != tt.want {
+				t.Errorf("ParseBookNDJSON error = %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func describeBooks(books []*Book) string {
+	list := []string{}
+	for _, book := range books {
+		list = append(list, fmt.Sprintf("%+v", *book))
+	}
+	return "[" + strings.Join(list, ", ") + "]"
+}
@@ -0,0 +1,104 @@
+package data
+
+import (
+	"strings"
+)
+
+// NormalizeISBN strips hyphens and spaces and turns a valid ISBN-10 into its
+// ISBN-13 form, which is what gets stored. Anything that isn't a valid ISBN
+// comes back stripped but otherwise untouched so ValidISBN can reject it.
+func NormalizeISBN(isbn string) string {
+	isbn = strings.ToUpper(strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(isbn)))
+
+	if validISBN10(isbn) {
+		body := "978" + isbn[:9]
+		return body + string(isbn13CheckDigit(body))
+	}
+
+	return isbn
+}
+
+// ValidISBN reports whether isbn is a canonical ISBN-13
+func ValidISBN(isbn string) bool {
+	if len(isbn) != 13 || !allDigits(isbn) {
+		return false
+	}
+
+	if !strings.HasPrefix(isbn, "978") && !strings.HasPrefix(isbn, "979") {
+		return false
+	}
+
+	return isbn13CheckDigit(isbn[:12]) == isbn[12]
+}
+
+// an ISBN-10 weights its digits 10 down to 1 and the total must divide by 11,
+// the last digit can be X for ten
+func validISBN10(isbn string) bool {
+	if len(isbn) != 10 || !allDigits(isbn[:9]) {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(isbn[i]-'0') * (10 - i)
+	}
+
+	switch check := isbn[9]; {
+	case check == 'X':
+		sum += 10
+	case check >= '0' && check <= '9':
+		sum += int(check - '0')
+	default:
+		return false
+	}
+
+	return sum%11 == 0
+}
+
+// the ISBN-13 check digit for the first twelve digits, weighted 1 and 3
+func isbn13CheckDigit(body string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(body[i]-'0') * weight
+	}
+
+	return byte('0' + (10-sum%10)%10)
+}
+
+func allDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ISBN10 gives the ISBN-10 form of a 978 ISBN-13, 979 numbers have none
+func ISBN10(isbn string) string {
+	if !ValidISBN(isbn) || !strings.HasPrefix(isbn, "978") {
+		return ""
+	}
+
+	body := isbn[3:12]
+
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(body[i]-'0') * (10 - i)
+	}
+
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return body + "X"
+	}
+	return body + string(rune('0'+check))
+}
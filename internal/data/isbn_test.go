@@ -0,0 +1,134 @@
+package data
+
+import "testing"
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"isbn-13", "9780061120084", "9780061120084"},
+		{"isbn-13 with hyphens", "978-0-06-112008-4", "9780061120084"},
+		{"isbn-13 with spaces", " 978 0 06 112008 4 ", "9780061120084"},
+		{"isbn-10", "0061120081", "9780061120084"},
+		{"isbn-10 with hyphens", "0-06-112008-1", "9780061120084"},
+		{"isbn-10 with spaces", "0 306 40615 2", "9780306406157"},
+		{"isbn-10 with X check digit", "080442957X", "9780804429573"},
+		{"isbn-10 with lower case x", "0-8044-2957-x", "9780804429573"},
+		{"invalid isbn-10 is only stripped", "0-06-112008-2", "0061120082"},
+		{"garbage is only stripped", "not an isbn", "NOTANISBN"},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NormalizeISBN(tt.input)
+			if got != tt.want {
+				t.Errorf("NormalizeISBN(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidISBN(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  bool
+	}{
+		{"978 isbn-13", "9780061120084", true},
+		{"979 isbn-13", "9791234567896", true},
+		{"isbn-13 from an X isbn-10", "9780804429573", true},
+		{"wrong check digit", "9780061120085", false},
+		{"wrong prefix", "9770061120087", false},
+		{"hyphens are not canonical", "978-0-06-112008-4", false},
+		{"isbn-10 is not canonical", "0061120081", false},
+		{"too short", "978006112008", false},
+		{"too long", "97800611200840", false},
+		{"letters", "97800611200X4", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ValidISBN(tt.input)
+			if got != tt.want {
+				t.Errorf("ValidISBN(%q) = %t, want %t", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidISBN10(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"0061120081", true},
+		{"0306406152", true},
+		{"080442957X", true},
+		{"043942089X", true},
+		{"0061120082", false},
+		{"0804429570", false},
+		{"X061120081", false},
+		{"006112008", false},
+		{"00611200811", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got := validISBN10(tt.input)
+			if got != tt.want {
+				t.Errorf("validISBN10(%q) = %t, want %t", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestISBN10RoundTrip(t *testing.T) {
+	tests := []struct {
+		isbn10 string
+		isbn13 string
+	}{
+		{"0061120081", "9780061120084"},
+		{"0306406152", "9780306406157"},
+		{"080442957X", "9780804429573"},
+		{"043942089X", "9780439420891"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.isbn10, func(t *testing.T) {
+			isbn13 := NormalizeISBN(tt.isbn10)
+			if isbn13 != tt.isbn13 {
+				t.Fatalf("NormalizeISBN(%q) = %q, want %q", tt.isbn10, isbn13, tt.isbn13)
+			}
+
+			isbn10 := ISBN10(isbn13)
+			if isbn10 != tt.isbn10 {
+				t.Errorf("ISBN10(%q) = %q, want %q", isbn13, isbn10, tt.isbn10)
+			}
+		})
+	}
+}
+
+func TestISBN10WithoutTenDigitForm(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"979 prefix", "9791234567896"},
+		{"invalid isbn-13", "9780061120085"},
+		{"isbn-10 input", "0061120081"},
+		{"empty", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ISBN10(tt.input)
+			if got != "" {
+				t.Errorf("ISBN10(%q) = %q, want \"\"", tt.input, got)
+			}
+		})
+	}
+}
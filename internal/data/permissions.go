@@ -0,0 +1,118 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const PermissionBooksRead = "books:read"
+const PermissionBooksWrite = "books:write"
+const PermissionAdmin = "admin"
+
+// each role is just a named set of permission codes
+var Roles = map[string]Permissions{
+	"member":    {PermissionBooksRead},
+	"librarian": {PermissionBooksRead, PermissionBooksWrite},
+	"admin":     {PermissionBooksRead, PermissionBooksWrite, PermissionAdmin},
+}
+
+type Permissions []string
+
+func (p Permissions) Include(code string) bool {
+	return slices.Contains(p, code)
+}
+
+type PermissionModel struct {
+	DB           *sql.DB
+	QueryTimeout time.Duration
+}
+
+func (p PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	query := `
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+	INNER JOIN users ON users_permissions.user_id = users.id
+	WHERE users.id = $1
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, p.QueryTimeout)
+	defer cancel()
+
+	rows, err := p.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// AddForUser grants codes on top of whatever the user already has, codes
+// they hold already are left alone
+func (p PermissionModel) AddForUser(ctx context.Context, userID int64, codes ...string) error {
+	query := `
+	INSERT INTO users_permissions
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, p.QueryTimeout)
+	defer cancel()
+
+	_, err := p.DB.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
+
+// SetForUser replaces every permission the user has with codes. It runs in
+// one transaction so a failure never leaves the user with none at all.
+func (p PermissionModel) SetForUser(ctx context.Context, userID int64, codes ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.QueryTimeout)
+	defer cancel()
+
+	return runTx(ctx, p.DB, func(tx *sql.Tx) error {
+		return setPermissions(ctx, tx, userID, codes)
+	})
+}
+
+func setPermissions(ctx context.Context, q querier, userID int64, codes []string) error {
+	query := `
+	DELETE FROM users_permissions
+	WHERE user_id = $1
+	`
+
+	_, err := q.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+	INSERT INTO users_permissions
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	`
+
+	_, err = q.ExecContext(ctx, query, userID, pq.Array(codes))
+	return err
+}
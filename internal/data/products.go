@@ -18,7 +18,8 @@ type Product struct {
 }
 
 type BookClub struct {
-	DB *sql.DB
+	DB           *sql.DB
+	QueryTimeout time.Duration
 }
 
 // func (p ProductModel) Insert(product *Product) error {
@@ -2,11 +2,15 @@ package data
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base32"
 	"errors"
 	"fmt"
-	"log"
+	"slices"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type ReadListInt struct {
@@ -15,74 +19,177 @@ type ReadListInt struct {
 	Description string `json:"description"`
 	Created_by  int64  `json:"created_by"`
 	Status      string `json:"status"`
+	Visibility  string `json:"visibility"`
+	ShareToken  string `json:"share_token,omitempty"`
 	Book        []*Book
 }
 
 type ReadList struct {
-	ID          int64  `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	Created_by  string `json:"created_by"`
-	Status      string `json:"status"`
-	Book        []*Book
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Created_by  string    `json:"created_by"`
+	Status      string    `json:"status"`
+	Visibility  string    `json:"visibility"`
+	ShareToken  string    `json:"share_token,omitempty"`
+	Version     int       `json:"version"`
+	Updated_at  time.Time `json:"updated_at"`
+	Book        []*ListBook
+}
+
+// who can see a reading list. Public lists show up for everyone, private
+// ones only for their owner and unlisted ones for their owner and anyone
+// holding the share token.
+const (
+	ListPublic   = "public"
+	ListPrivate  = "private"
+	ListUnlisted = "unlisted"
+)
+
+var ListVisibilities = []string{ListPublic, ListPrivate, ListUnlisted}
+
+// listVisibleTo is the condition for the lists the viewer in the argument
+// at argPosition may see. Anonymous viewers are user 0 and only see public
+// lists.
+func listVisibleTo(argPosition int) string {
+	return fmt.Sprintf("(R.visibility = 'public' OR R.created_by = $%d)", argPosition)
+}
+
+// listShareToken selects the share token for the owner and an empty string
+// for everyone else
+func listShareToken(argPosition int) string {
+	return fmt.Sprintf("CASE WHEN R.created_by = $%d THEN COALESCE(R.share_token, '') ELSE '' END", argPosition)
+}
+
+// generateShareToken makes the secret part of an unlisted list's share link
+func generateShareToken() (string, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+// where a reader is with one book of a list
+const (
+	EntryWantToRead = "want-to-read"
+	EntryReading    = "reading"
+	EntryFinished   = "finished"
+	EntryAbandoned  = "abandoned"
+)
+
+var EntryStatuses = []string{EntryWantToRead, EntryReading, EntryFinished, EntryAbandoned}
+
+// ListBook is a book as it appears on a reading list, with the reader's
+// progress through it
+type ListBook struct {
+	Book
+	Position    int        `json:"position"`
+	Status      string     `json:"status"`
+	CurrentPage *int       `json:"current_page"`
+	Percent     *float64   `json:"percent"`
+	Started_at  *time.Time `json:"started_at"`
+	Finished_at *time.Time `json:"finished_at"`
 }
 
-func (b BookClub) InsertList(readList *ReadListInt) error {
+const listBookSelect = `SELECT ` + bookColumns + `,
+	BL.position, BL.status, BL.current_page, BL.percent, BL.started_at, BL.finished_at
+	` + bookJoins + `
+	INNER JOIN book_list AS BL
+	ON BL.book_id = B.id
+	`
 
-	err := b.DoesUserExists(readList.Created_by)
+// listBookFields lines up with the columns of listBookSelect for rows.Scan
+func listBookFields(book *ListBook) []any {
+	return append(bookFields(&book.Book), &book.Position, &book.Status, &book.CurrentPage, &book.Percent, &book.Started_at, &book.Finished_at)
+}
+
+func (b BookClub) InsertList(ctx context.Context, readList *ReadListInt, status int64) error {
+
+	err := b.DoesUserExists(ctx, readList.Created_by)
+
+	if err != nil {
+		return err
+	}
 
+	shareToken, err := generateShareToken()
 	if err != nil {
 		return err
 	}
 
+	// only unlisted lists get a share link
 	query := `
-	INSERT INTO readList(name, description, created_by, status)
-	VALUES ($1, $2, $3, 1)
-	RETURNING id
+	INSERT INTO readList(name, description, created_by, status, visibility, share_token)
+	VALUES ($1, $2, $3, $4, $5::varchar, CASE WHEN $5::varchar = 'unlisted' THEN $6 END)
+	RETURNING id, COALESCE(share_token, '')
 	
 	`
 
-	args := []any{readList.Name, readList.Description, readList.Created_by}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	args := []any{readList.Name, readList.Description, readList.Created_by, status, readList.Visibility, shareToken}
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	return b.DB.QueryRowContext(ctx, query, args...).Scan(&readList.ID)
+	return b.DB.QueryRowContext(ctx, query, args...).Scan(&readList.ID, &readList.ShareToken)
 
 }
 
-func (b BookClub) GetAllLists(filters Filters) ([]*ReadList, error) {
+// the expression behind each sort key that GetAllLists accepts
+var listSortColumns = map[string]string{
+	"id": "R.id",
+}
+
+// GetAllLists returns the lists viewer is allowed to see
+func (b BookClub) GetAllLists(ctx context.Context, viewer int64, filters Filters) ([]*ReadList, Metadata, error) {
+	sortExpression, err := filters.sortExpression(listSortColumns)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	seek, seekArgs, err := filters.seek(sortExpression, "R.id", 4)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	query := fmt.Sprintf(`
-	SELECT R.id, R.name, R.description, U.username AS created_by, S.name as status 
+	SELECT count(*) OVER(), R.id, R.name, R.description, U.username AS created_by, S.name as status,
+		R.visibility, %s, R.version, R.updated_at, (%s)::text
 	FROM readList AS R 
 	INNER JOIN users AS U 
 	ON R.created_by = U.id 
 	INNER JOIN status AS S 
 	ON R.status = S.id
-	ORDER BY %s %s, R.id ASC
+	WHERE %s AND %s
+	ORDER BY %s %s, R.id %s
 	LIMIT $1 OFFSET $2
-	`, filters.sortColumn(), filters.sortDirection())
+	`, listShareToken(3), sortExpression, listVisibleTo(3), seek, sortExpression, filters.sortDirection(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	rows, err := b.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	args := append([]any{filters.limit(), filters.offset(), viewer}, seekArgs...)
+
+	rows, err := b.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
 	defer rows.Close()
 
+	totalRecords := 0
+	cursorValue := ""
 	readLists := []*ReadList{}
 
 	for rows.Next() {
 		var readList ReadList
-		err := rows.Scan(&readList.ID, &readList.Name, &readList.Description, &readList.Created_by, &readList.Status)
+		err := rows.Scan(&totalRecords, &readList.ID, &readList.Name, &readList.Description, &readList.Created_by, &readList.Status, &readList.Visibility, &readList.ShareToken, &readList.Version, &readList.Updated_at, &cursorValue)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
-		readList.Book, err = b.GetAllById(readList.ID)
+		readList.Book, err = b.GetAllById(ctx, readList.ID)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 
 		readLists = append(readLists, &readList)
@@ -90,63 +197,249 @@ func (b BookClub) GetAllLists(filters Filters) ([]*ReadList, error) {
 
 	err = rows.Err()
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
+	}
+
+	nextCursor := ""
+	if len(readLists) > 0 {
+		nextCursor = filters.nextCursor(len(readLists), cursorValue, readLists[len(readLists)-1].ID)
 	}
 
-	return readLists, nil
+	return readLists, filters.metadata(totalRecords, nextCursor), nil
 }
 
-func (b BookClub) ListAddBook(id int64, bid int64) error {
-	err := b.DoesBookExists(bid)
+func (b BookClub) ListAddBook(ctx context.Context, id int64, bid int64) error {
+	err := b.DoesBookExists(ctx, bid)
 
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return BookNotFound
+		default:
+			return err
+		}
 	}
 
-	err = b.DoesListExists(id)
+	err = b.DoesListExists(ctx, id)
 
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	return b.withTx(ctx, func(tx *sql.Tx) error {
+		err := lockList(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		// new books go to the end of the list
+		query := `
+		INSERT INTO book_list (book_id, list_id, position)
+		SELECT $1, $2, COALESCE(MAX(position), 0) + 1
+		FROM book_list
+		WHERE list_id = $2
+		RETURNING id
+		`
+
+		var entryID int64
+
+		err = tx.QueryRowContext(ctx, query, bid, id).Scan(&entryID)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "book_list_list_id_book_id_key"`:
+				return ErrDuplicateListBook
+			default:
+				return err
+			}
+		}
+
+		return nil
+	})
+
+}
+
+// lockList holds the list's row until the transaction ends, so edits to the
+// order of its books happen one at a time
+func lockList(ctx context.Context, q querier, id int64) error {
 	query := `
-	INSERT INTO book_list (book_id, list_id)
-	VALUES ($1, $2)
-	RETURNING id
-	
+	SELECT id
+	FROM readList
+	WHERE id = $1
+	FOR UPDATE
 	`
 
-	args := []any{bid, id}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	err := q.QueryRowContext(ctx, query, id).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// listOrder is the ids of the books on a list, first to last
+func listOrder(ctx context.Context, q querier, id int64) ([]int64, error) {
+	query := `
+	SELECT book_id
+	FROM book_list
+	WHERE list_id = $1
+	ORDER BY position ASC
+	`
+
+	rows, err := q.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	bookIDs := []int64{}
+
+	for rows.Next() {
+		var bookID int64
+		err := rows.Scan(&bookID)
+		if err != nil {
+			return nil, err
+		}
+
+		bookIDs = append(bookIDs, bookID)
+	}
+
+	return bookIDs, rows.Err()
+}
+
+// setListOrder numbers the books of a list from 1 in the order given. The
+// position constraint is deferred, so positions may collide until commit.
+func setListOrder(ctx context.Context, q querier, id int64, bookIDs []int64) error {
+	query := `
+	UPDATE book_list AS BL
+	SET position = O.position
+	FROM unnest($2::bigint[]) WITH ORDINALITY AS O(book_id, position)
+	WHERE BL.list_id = $1 AND BL.book_id = O.book_id AND BL.position <> O.position
+	`
+
+	_, err := q.ExecContext(ctx, query, id, pq.Array(bookIDs))
+	return err
+}
+
+// ReorderList puts the books of a list in the order given, which has to name
+// every book on the list exactly once
+func (b BookClub) ReorderList(ctx context.Context, id int64, bookIDs []int64) error {
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	return b.DB.QueryRowContext(ctx, query, args...).Scan(&id)
+	return b.withTx(ctx, func(tx *sql.Tx) error {
+		err := lockList(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		current, err := listOrder(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if len(current) != len(bookIDs) {
+			return ErrInvalidOrder
+		}
 
+		for _, bookID := range bookIDs {
+			if !slices.Contains(current, bookID) {
+				return ErrInvalidOrder
+			}
+		}
+
+		return setListOrder(ctx, tx, id, bookIDs)
+	})
+}
+
+// MoveListBook moves one book to position, counting from 1. Positions past
+// the end of the list move it to the end.
+func (b BookClub) MoveListBook(ctx context.Context, id int64, bid int64, position int) error {
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	return b.withTx(ctx, func(tx *sql.Tx) error {
+		err := lockList(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		bookIDs, err := listOrder(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		index := slices.Index(bookIDs, bid)
+		if index == -1 {
+			return ErrRecordNotFound
+		}
+
+		bookIDs = slices.Delete(bookIDs, index, index+1)
+		position = min(position, len(bookIDs)+1)
+		bookIDs = slices.Insert(bookIDs, position-1, bid)
+
+		return setListOrder(ctx, tx, id, bookIDs)
+	})
 }
 
-func (b BookClub) GetList(id int64) (*ReadList, error) {
+// GetList returns a list if viewer is allowed to see it. Lists that are
+// hidden from the viewer are reported as not found.
+func (b BookClub) GetList(ctx context.Context, id int64, viewer int64) (*ReadList, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `
-	SELECT R.id, R.name, R.description, U.username AS created_by, S.name as status 
+	query := fmt.Sprintf(`
+	%s
+	WHERE R.id = $1 AND %s
+
+	`, listSelect(listShareToken(2)), listVisibleTo(2))
+
+	return b.getList(ctx, query, id, viewer)
+}
+
+// GetSharedList returns the unlisted list a share token belongs to
+func (b BookClub) GetSharedList(ctx context.Context, token string) (*ReadList, error) {
+	query := fmt.Sprintf(`
+	%s
+	WHERE R.share_token = $1 AND R.visibility = 'unlisted'
+	`, listSelect("''"))
+
+	return b.getList(ctx, query, token)
+}
+
+// listSelect is the SELECT behind GetList and GetSharedList, shareToken is
+// the expression for the share_token column
+func listSelect(shareToken string) string {
+	return fmt.Sprintf(`
+	SELECT R.id, R.name, R.description, U.username AS created_by, S.name as status,
+		R.visibility, %s, R.version, R.updated_at
 	FROM readList AS R 
 	INNER JOIN users AS U 
 	ON R.created_by = U.id 
 	INNER JOIN status AS S 
-	ON R.status = S.id
-	WHERE R.id = $1
-
-	`
-
-	args := []any{id}
+	ON R.status = S.id`, shareToken)
+}
 
+func (b BookClub) getList(ctx context.Context, query string, args ...any) (*ReadList, error) {
 	var readList ReadList
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	err := b.DB.QueryRowContext(ctx, query, args...).Scan(&readList.ID, &readList.Name, &readList.Description, &readList.Created_by, &readList.Status)
+	err := b.DB.QueryRowContext(ctx, query, args...).Scan(&readList.ID, &readList.Name, &readList.Description, &readList.Created_by, &readList.Status, &readList.Visibility, &readList.ShareToken, &readList.Version, &readList.Updated_at)
 
 	if err != nil {
 		switch {
@@ -157,70 +450,90 @@ func (b BookClub) GetList(id int64) (*ReadList, error) {
 		}
 	}
 
-	readList.Book, _ = b.GetAllById(id)
+	readList.Book, err = b.GetAllById(ctx, readList.ID)
+	if err != nil {
+		return nil, err
+	}
 
 	return &readList, nil
 
 }
 
-func (b BookClub) UpdateList(readList *ReadList, id int64, uid int64, status int64) error {
-
-	err := b.DoesUserExists(uid)
-
-	if uid != 0 {
-		if err != nil {
-
-			return err
-		}
+// UpdateList stores a list's name, description, status and visibility. The
+// owner is not editable. An unlisted list keeps its share token, any other
+// visibility revokes it so going back to unlisted makes a new link.
+func (b BookClub) UpdateList(ctx context.Context, readList *ReadList, id int64, status int64) error {
+	shareToken, err := generateShareToken()
+	if err != nil {
+		return err
 	}
 
-	if uid == 0 {
-
-		query := `
-		SELECT created_by FROM readList WHERE id=$1
-		`
+	query := `
+	UPDATE readList
+	SET name=$1, description=$2, status=$3, visibility=$4::varchar,
+		share_token = CASE WHEN $4::varchar = 'unlisted' THEN COALESCE(share_token, $5) END,
+		version = version + 1
+	WHERE id = $6 AND version = $7
+	RETURNING COALESCE(share_token, ''), version, updated_at
+	`
 
-		args := []any{id}
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		defer cancel()
+	args := []any{readList.Name, readList.Description, status, readList.Visibility, shareToken, id, readList.Version}
 
-		err := b.DB.QueryRowContext(ctx, query, args...).Scan(&uid)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
 
-		if err != nil {
+	err = b.DB.QueryRowContext(ctx, query, args...).Scan(&readList.ShareToken, &readList.Version, &readList.Updated_at)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
 			return err
 		}
 	}
 
-	query := `
-	UPDATE readList
-	SET name=$1, description=$2, created_by=$3, status=$4
-	WHERE id = $5
-	RETURNING id
-
+	return nil
+}
 
-	`
+func (b BookClub) GetListBook(ctx context.Context, id int64, bid int64) (*ListBook, error) {
+	query := fmt.Sprintf(`
+	%s
+	WHERE BL.list_id = $1 AND BL.book_id = $2
+	GROUP BY B.id, BL.id
+	`, listBookSelect)
 
-	log.Println(status)
+	var book ListBook
 
-	args := []any{readList.Name, readList.Description, uid, status, id}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	return b.DB.QueryRowContext(ctx, query, args...).Scan(&id)
+	err := b.DB.QueryRowContext(ctx, query, id, bid).Scan(listBookFields(&book)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
 
+	return &book, nil
 }
 
-func (b BookClub) DeleteList(id int64) error {
-
+// UpdateListBook stores the reading status and progress of a book on a list
+func (b BookClub) UpdateListBook(ctx context.Context, id int64, book *ListBook) error {
 	query := `
-	DELETE FROM readList
-	WHERE id = $1
+	UPDATE book_list
+	SET status = $1, current_page = $2, percent = $3, started_at = $4, finished_at = $5
+	WHERE list_id = $6 AND book_id = $7
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	args := []any{book.Status, book.CurrentPage, book.Percent, book.Started_at, book.Finished_at, id, book.ID}
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	result, err := b.DB.ExecContext(ctx, query, id)
+	result, err := b.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
@@ -237,23 +550,17 @@ func (b BookClub) DeleteList(id int64) error {
 	return nil
 }
 
-func (b BookClub) DeleteFromList(id int64, lid int64) error {
-
-	err := b.DoesListExists(lid)
-
-	if err != nil {
-		return err
-	}
+func (b BookClub) DeleteList(ctx context.Context, id int64) error {
 
 	query := `
-	DELETE FROM book_list
-	WHERE book_id = $1 AND list_id = $2
+	DELETE FROM readList
+	WHERE id = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	result, err := b.DB.ExecContext(ctx, query, id, lid)
+	result, err := b.DB.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -269,3 +576,54 @@ func (b BookClub) DeleteFromList(id int64, lid int64) error {
 
 	return nil
 }
+
+func (b BookClub) DeleteFromList(ctx context.Context, id int64, lid int64) error {
+
+	err := b.DoesListExists(ctx, lid)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	return b.withTx(ctx, func(tx *sql.Tx) error {
+		err := lockList(ctx, tx, lid)
+		if err != nil {
+			return err
+		}
+
+		query := `
+		DELETE FROM book_list
+		WHERE book_id = $1 AND list_id = $2
+		`
+
+		result, err := tx.ExecContext(ctx, query, id, lid)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+
+		// close the gap the book left
+		bookIDs, err := listOrder(ctx, tx, lid)
+		if err != nil {
+			return err
+		}
+
+		return setListOrder(ctx, tx, lid, bookIDs)
+	})
+}
@@ -5,7 +5,6 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
 	"time"
 )
 
@@ -16,6 +15,8 @@ type ReviewIn struct {
 	Review     string    `json:"review"`
 	Created_at time.Time `json:"created_at"`
 	Rating     float64   `json:"rating"`
+	Version    int       `json:"version"`
+	Updated_at time.Time `json:"updated_at"`
 }
 
 type Review struct {
@@ -25,17 +26,18 @@ type Review struct {
 	Review     string    `json:"review"`
 	Created_at time.Time `json:"created_at"`
 	Rating     float64   `json:"rating"`
+	Updated_at time.Time `json:"updated_at"`
 }
 
-func (b BookClub) InsertReview(review *ReviewIn) error {
+func (b BookClub) InsertReview(ctx context.Context, review *ReviewIn) error {
 
-	err := b.DoesBookExists(review.Book_id)
+	err := b.DoesBookExists(ctx, review.Book_id)
 
 	if err != nil {
 		return BookNotFound
 	}
 
-	err = b.DoesUserExists(review.User_id)
+	err = b.DoesUserExists(ctx, review.User_id)
 
 	if err != nil {
 		return UserNotFound
@@ -50,52 +52,74 @@ func (b BookClub) InsertReview(review *ReviewIn) error {
 	query := `
 	
 	INSERT INTO book_reviews (book_id, user_id, review, rating, created_at) 
-	VALUES ($1, $2, $3, $4, $5) RETURNING id;
+	VALUES ($1, $2, $3, $4, $5) RETURNING id, version, updated_at;
 	
 	`
 
 	args := []any{review.Book_id, review.User_id, review.Review, review.Rating, review.Created_at}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	err = b.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID)
+	return b.withTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.Version, &review.Updated_at)
 
-	if err != nil {
+		if err != nil {
 
-		return err
-	}
+			return err
+		}
 
-	return b.UpdateAverage(review.Book_id)
+		return updateAverage(ctx, tx, review.Book_id)
+	})
 
 }
 
-func (b BookClub) GetAllReviews(filters Filters, id int64) ([]*Review, error) {
+// the expression behind each sort key that GetAllReviews accepts
+var reviewSortColumns = map[string]string{
+	"id": "R.id",
+}
+
+func (b BookClub) GetAllReviews(ctx context.Context, filters Filters, id int64) ([]*Review, Metadata, error) {
+	sortExpression, err := filters.sortExpression(reviewSortColumns)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	seek, seekArgs, err := filters.seek(sortExpression, "R.id", 4)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
 	query := fmt.Sprintf(`
-	SELECT R.id, B.title, U.username, R.review, R.rating, R.created_at FROM book_reviews AS R
+	SELECT count(*) OVER(), R.id, B.title, U.username, R.review, R.rating, R.created_at, R.updated_at, (%s)::text FROM book_reviews AS R
 	INNER JOIN books AS B ON R.book_id = B.id 
 	INNER JOIN users AS U ON R.user_id = U.id
 	WHERE R.book_id = $3
-	ORDER BY %s %s, B.id ASC
+	AND %s
+	ORDER BY %s %s, R.id %s
 	LIMIT $1 OFFSET $2
-	`, filters.sortColumn(), filters.sortDirection())
+	`, sortExpression, seek, sortExpression, filters.sortDirection(), filters.sortDirection())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	rows, err := b.DB.QueryContext(ctx, query, filters.limit(), filters.offset(), id)
+	args := append([]any{filters.limit(), filters.offset(), id}, seekArgs...)
+
+	rows, err := b.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
 	defer rows.Close()
 
+	totalRecords := 0
+	cursorValue := ""
 	reviews := []*Review{}
 
 	for rows.Next() {
 		var review Review
-		err := rows.Scan(&review.ID, &review.Book, &review.User, &review.Review, &review.Rating, &review.Created_at)
+		err := rows.Scan(&totalRecords, &review.ID, &review.Book, &review.User, &review.Review, &review.Rating, &review.Created_at, &review.Updated_at, &cursorValue)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 
 		reviews = append(reviews, &review)
@@ -103,13 +127,18 @@ func (b BookClub) GetAllReviews(filters Filters, id int64) ([]*Review, error) {
 
 	err = rows.Err()
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
-	return reviews, nil
+	nextCursor := ""
+	if len(reviews) > 0 {
+		nextCursor = filters.nextCursor(len(reviews), cursorValue, reviews[len(reviews)-1].ID)
+	}
+
+	return reviews, filters.metadata(totalRecords, nextCursor), nil
 }
 
-func (b BookClub) DeleteReview(id int64) error {
+func (b BookClub) DeleteReview(ctx context.Context, id int64) error {
 
 	query := `
 	DELETE FROM book_reviews
@@ -117,27 +146,29 @@ func (b BookClub) DeleteReview(id int64) error {
 	RETURNING book_id
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
-	var bookID int64
-	err := b.DB.QueryRowContext(ctx, query, id).Scan(&bookID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return ErrRecordNotFound
+
+	return b.withTx(ctx, func(tx *sql.Tx) error {
+		var bookID int64
+		err := tx.QueryRowContext(ctx, query, id).Scan(&bookID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrRecordNotFound
+			}
+			return err
 		}
-		return err
-	}
 
-	log.Println(bookID)
-	return b.UpdateAverage(bookID)
+		return updateAverage(ctx, tx, bookID)
+	})
 }
 
-func (b BookClub) GetReview(id int64) (*ReviewIn, error) {
+func (b BookClub) GetReview(ctx context.Context, id int64) (*ReviewIn, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
 	query := `
-	SELECT id, book_id, user_id, review, rating, created_at
+	SELECT id, book_id, user_id, review, rating, created_at, version, updated_at
 	FROM book_reviews 
 	WHERE id = $1
 
@@ -147,12 +178,11 @@ func (b BookClub) GetReview(id int64) (*ReviewIn, error) {
 
 	var review ReviewIn
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	err := b.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.Book_id, &review.User_id, &review.Review, &review.Rating, &review.Created_at)
+	err := b.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.Book_id, &review.User_id, &review.Review, &review.Rating, &review.Created_at, &review.Version, &review.Updated_at)
 	if err != nil {
-		log.Println("hello")
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
 			return nil, ErrRecordNotFound
@@ -164,27 +194,32 @@ func (b BookClub) GetReview(id int64) (*ReviewIn, error) {
 
 }
 
-func (b BookClub) UpdateReview(review *ReviewIn, id int64) error {
+func (b BookClub) UpdateReview(ctx context.Context, review *ReviewIn, id int64) error {
 
 	query := `
 	UPDATE book_reviews
-	SET review = $2, rating = $3
-	WHERE id = $1
-	RETURNING book_id
-
-
+	SET review = $2, rating = $3, version = version + 1
+	WHERE id = $1 AND version = $4
+	RETURNING book_id, version, updated_at
 	`
 
-	args := []any{id, review.Review, review.Rating}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	args := []any{id, review.Review, review.Rating, review.Version}
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
 	defer cancel()
 
-	err := b.DB.QueryRowContext(ctx, query, args...).Scan(&review.Book_id)
+	return b.withTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&review.Book_id, &review.Version, &review.Updated_at)
 
-	if err != nil {
-		return err
-	}
-	log.Println("swag")
-	return b.UpdateAverage(review.Book_id)
+		if err != nil {
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+				return ErrEditConflict
+			default:
+				return err
+			}
+		}
+
+		return updateAverage(ctx, tx, review.Book_id)
+	})
 
 }
@@ -0,0 +1,228 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/Jcastel2014/test3/internal/validator"
+)
+
+// every search query is bound to $3 so the rank can be used as a sort key
+const searchQuery = `websearch_to_tsquery('english', $3)`
+
+var bookSearchSortColumns = map[string]string{
+	"rank":  "ts_rank_cd(B.search_vector, " + searchQuery + ")",
+	"id":    "B.id",
+	"title": "B.title",
+}
+
+var reviewSearchSortColumns = map[string]string{
+	"rank": "ts_rank_cd(R.search_vector, " + searchQuery + ")",
+	"id":   "R.id",
+}
+
+// BookSearch is a free text query plus optional terms that must match a
+// particular field. The field terms follow the weights the search_vector
+// column was built with: A title, B authors, C genre.
+type BookSearch struct {
+	Query  string
+	Title  string
+	Author string
+	Genre  string
+}
+
+type BookSearchResult struct {
+	Book
+	Rank                 float64 `json:"rank"`
+	TitleHighlight       string  `json:"title_highlight"`
+	DescriptionHighlight string  `json:"description_highlight"`
+}
+
+type ReviewSearchResult struct {
+	Review
+	BookID    int64   `json:"book_id"`
+	Rank      float64 `json:"rank"`
+	Highlight string  `json:"highlight"`
+}
+
+// terms is everything the search should rank on
+func (s BookSearch) terms() string {
+	terms := []string{}
+	for _, term := range []string{s.Query, s.Title, s.Author, s.Genre} {
+		if term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+func (s BookSearch) where(argPosition int) (string, []any) {
+	conditions := []string{"B.search_vector @@ " + searchQuery}
+	args := []any{}
+
+	fields := []struct {
+		weight string
+		term   string
+	}{
+		{"a", s.Title},
+		{"b", s.Author},
+		{"c", s.Genre},
+	}
+
+	for _, field := range fields {
+		if field.term == "" {
+			continue
+		}
+		conditions = append(conditions, fmt.Sprintf("ts_filter(B.search_vector, '{%s}') @@ plainto_tsquery('english', $%d)", field.weight, argPosition+len(args)))
+		args = append(args, field.term)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+func (b BookClub) SearchBooks(ctx context.Context, search BookSearch, filters Filters) ([]*BookSearchResult, Metadata, error) {
+	sortExpression, err := filters.sortExpression(bookSearchSortColumns)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	args := []any{filters.limit(), filters.offset(), search.terms()}
+
+	where, whereArgs := search.where(len(args) + 1)
+	args = append(args, whereArgs...)
+
+	seek, seekArgs, err := filters.seek(sortExpression, "B.id", len(args)+1)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	args = append(args, seekArgs...)
+
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), %s,
+		ts_rank_cd(B.search_vector, %s),
+		ts_headline('english', B.title, %s, 'HighlightAll=true'),
+		ts_headline('english', COALESCE(B.description, ''), %s, 'MaxFragments=2, MaxWords=30, MinWords=10'),
+		(%s)::text %s
+	WHERE %s
+	AND %s
+	GROUP BY B.id
+	ORDER BY %s %s, B.id %s
+	LIMIT $1 OFFSET $2
+	`, bookColumns, searchQuery, searchQuery, searchQuery, sortExpression, bookJoins, where, seek, sortExpression, filters.sortDirection(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	rows, err := b.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	cursorValue := ""
+	results := []*BookSearchResult{}
+
+	for rows.Next() {
+		var result BookSearchResult
+
+		dest := append([]any{&totalRecords}, bookFields(&result.Book)...)
+		dest = append(dest, &result.Rank, &result.TitleHighlight, &result.DescriptionHighlight, &cursorValue)
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		results = append(results, &result)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	nextCursor := ""
+	if len(results) > 0 {
+		nextCursor = filters.nextCursor(len(results), cursorValue, results[len(results)-1].ID)
+	}
+
+	return results, filters.metadata(totalRecords, nextCursor), nil
+}
+
+func (b BookClub) SearchReviews(ctx context.Context, q string, filters Filters) ([]*ReviewSearchResult, Metadata, error) {
+	sortExpression, err := filters.sortExpression(reviewSearchSortColumns)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	seek, seekArgs, err := filters.seek(sortExpression, "R.id", 4)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), R.id, R.book_id, B.title, U.username, R.review, R.rating, R.created_at, R.updated_at,
+		ts_rank_cd(R.search_vector, %s),
+		ts_headline('english', R.review, %s, 'MaxFragments=2, MaxWords=30, MinWords=10'),
+		(%s)::text
+	FROM book_reviews AS R
+	INNER JOIN books AS B ON R.book_id = B.id
+	INNER JOIN users AS U ON R.user_id = U.id
+	WHERE R.search_vector @@ %s
+	AND %s
+	ORDER BY %s %s, R.id %s
+	LIMIT $1 OFFSET $2
+	`, searchQuery, searchQuery, sortExpression, searchQuery, seek, sortExpression, filters.sortDirection(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	args := append([]any{filters.limit(), filters.offset(), q}, seekArgs...)
+
+	rows, err := b.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	defer rows.Close()
+
+	totalRecords := 0
+	cursorValue := ""
+	results := []*ReviewSearchResult{}
+
+	for rows.Next() {
+		var result ReviewSearchResult
+		err := rows.Scan(&totalRecords, &result.ID, &result.BookID, &result.Book, &result.User, &result.Review, &result.Rating, &result.Created_at, &result.Updated_at,
+			&result.Rank, &result.Highlight, &cursorValue)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		results = append(results, &result)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	nextCursor := ""
+	if len(results) > 0 {
+		nextCursor = filters.nextCursor(len(results), cursorValue, results[len(results)-1].ID)
+	}
+
+	return results, filters.metadata(totalRecords, nextCursor), nil
+}
+
+func ValidateBookSearch(v *validator.Validator, search BookSearch) {
+	v.Check(search.terms() != "", "q", "must be provided, or one of title, author or genre")
+	v.Check(len(search.terms()) <= 200, "q", "must not be more than 200 characters long")
+}
+
+func ValidateReviewSearch(v *validator.Validator, q string) {
+	v.Check(q != "", "q", "must be provided")
+	v.Check(len(q) <= 200, "q", "must not be more than 200 characters long")
+}
@@ -0,0 +1,111 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/Jcastel2014/test3/internal/validator"
+)
+
+// Status is where a reader is with a whole reading list. The statuses live
+// in the status table and are managed by admins.
+type Status struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// DefaultListStatus is given to new lists that don't ask for a status
+const DefaultListStatus = "Currently Reading"
+
+func (b BookClub) GetAllStatuses(ctx context.Context) ([]*Status, error) {
+	query := `
+	SELECT id, name
+	FROM status
+	ORDER BY id ASC
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	rows, err := b.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	statuses := []*Status{}
+
+	for rows.Next() {
+		var status Status
+		err := rows.Scan(&status.ID, &status.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		statuses = append(statuses, &status)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+// GetStatusByName matches the name case-insensitively, the status comes
+// back spelled as it is stored
+func (b BookClub) GetStatusByName(ctx context.Context, name string) (*Status, error) {
+	query := `
+	SELECT id, name
+	FROM status
+	WHERE lower(name) = lower($1)
+	`
+
+	var status Status
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	err := b.DB.QueryRowContext(ctx, query, name).Scan(&status.ID, &status.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &status, nil
+}
+
+func (b BookClub) InsertStatus(ctx context.Context, status *Status) error {
+	query := `
+	INSERT INTO status (name)
+	VALUES ($1)
+	RETURNING id
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, b.QueryTimeout)
+	defer cancel()
+
+	err := b.DB.QueryRowContext(ctx, query, status.Name).Scan(&status.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "status_name_key"`:
+			return ErrDuplicateStatus
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ValidateStatus(v *validator.Validator, status *Status) {
+	v.Check(status.Name != "", "name", "must be provided")
+	v.Check(len(status.Name) <= 20, "name", "must not be more than 20 characters long")
+}
@@ -13,17 +13,31 @@ import (
 
 const ScopeActivation = "Activation"
 const ScopeAuthentication = "Authentication"
+const ScopePasswordReset = "PasswordReset"
 
 type Token struct {
-	PlainText string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserID    int       `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	ID         int64      `json:"id"`
+	PlainText  string     `json:"token,omitempty"`
+	Hash       []byte     `json:"-"`
+	UserID     int        `json:"-"`
+	Expiry     time.Time  `json:"expiry"`
+	Scope      string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+	IP         string     `json:"ip,omitempty"`
+}
+
+// Session is an authentication token as listed back to its owner, with
+// current marking the one the listing request was made with
+type Session struct {
+	*Token
+	Current bool `json:"current"`
 }
 
 type TokenModel struct {
-	DB *sql.DB
+	DB           *sql.DB
+	QueryTimeout time.Duration
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -55,32 +69,47 @@ func ValidatetokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
-func (t *TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (t *TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = t.Insert(token)
+	err = t.Insert(ctx, token)
+	return token, err
+}
+
+// NewAuthentication records where the session was opened from so that the
+// user can recognise it later in their list of sessions
+func (t *TokenModel) NewAuthentication(ctx context.Context, userID int64, ttl time.Duration, userAgent, ip string) (*Token, error) {
+	token, err := generateToken(userID, ttl, ScopeAuthentication)
+	if err != nil {
+		return nil, err
+	}
+
+	token.UserAgent = userAgent
+	token.IP = ip
+
+	err = t.Insert(ctx, token)
 	return token, err
 }
 
-func (t *TokenModel) Insert(token *Token) error {
+func (t *TokenModel) Insert(ctx context.Context, token *Token) error {
 	query := `
-	INSERT INTO tokens (hash, user_id, expiry, scope)
-	VALUES ($1, $2, $3, $4)
+	INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, ip)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at
 	`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IP}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, t.QueryTimeout)
 	defer cancel()
 
-	_, err := t.DB.ExecContext(ctx, query, args...)
-	return err
+	return t.DB.QueryRowContext(ctx, query, args...).Scan(&token.ID, &token.CreatedAt)
 }
 
-func (t *TokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (t *TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	query := `
 	DELETE FROM tokens
 	WHERE scope = $1 AND user_id = $2
@@ -88,9 +117,115 @@ func (t *TokenModel) DeleteAllForUser(scope string, userID int64) error {
 
 	args := []any{scope, userID}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, t.QueryTimeout)
 	defer cancel()
 
 	_, err := t.DB.ExecContext(ctx, query, args...)
 	return err
 }
+
+func (t *TokenModel) GetAllForUser(ctx context.Context, scope string, userID int64) ([]*Token, error) {
+	query := `
+	SELECT id, hash, expiry, created_at, last_used_at, user_agent, ip
+	FROM tokens
+	WHERE scope = $1 AND user_id = $2 AND expiry > $3
+	ORDER BY created_at DESC, id DESC
+	`
+
+	args := []any{scope, userID, time.Now()}
+
+	ctx, cancel := context.WithTimeout(ctx, t.QueryTimeout)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tokens := []*Token{}
+
+	for rows.Next() {
+		token := Token{UserID: int(userID), Scope: scope}
+		err := rows.Scan(&token.ID, &token.Hash, &token.Expiry, &token.CreatedAt, &token.LastUsedAt, &token.UserAgent, &token.IP)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Touch stamps the session with when and where it was last used. Busy
+// clients only cause a write once a minute.
+func (t *TokenModel) Touch(ctx context.Context, tokenPlainText, userAgent, ip string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+
+	query := `
+	UPDATE tokens
+	SET last_used_at = NOW(), user_agent = $2, ip = $3
+	WHERE hash = $1
+	AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`
+
+	args := []any{tokenHash[:], userAgent, ip}
+
+	ctx, cancel := context.WithTimeout(ctx, t.QueryTimeout)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (t *TokenModel) Delete(ctx context.Context, scope, tokenPlainText string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlainText))
+
+	query := `
+	DELETE FROM tokens
+	WHERE scope = $1 AND hash = $2
+	`
+
+	args := []any{scope, tokenHash[:]}
+
+	ctx, cancel := context.WithTimeout(ctx, t.QueryTimeout)
+	defer cancel()
+
+	_, err := t.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+func (t *TokenModel) DeleteForUser(ctx context.Context, scope string, id int64, userID int64) error {
+	query := `
+	DELETE FROM tokens
+	WHERE scope = $1 AND id = $2 AND user_id = $3
+	`
+
+	args := []any{scope, id, userID}
+
+	ctx, cancel := context.WithTimeout(ctx, t.QueryTimeout)
+	defer cancel()
+
+	result, err := t.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
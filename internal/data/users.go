@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/Jcastel2014/test3/internal/validator"
@@ -29,10 +30,13 @@ type password struct {
 }
 
 type UserModel struct {
-	DB *sql.DB
+	DB           *sql.DB
+	QueryTimeout time.Duration
 }
 
-func (u *UserModel) Insert(user *User) error {
+// Insert creates the user along with the permissions they start out with,
+// both or neither are stored
+func (u *UserModel) Insert(ctx context.Context, user *User, permissions ...string) error {
 	query := `
 	INSERT INTO users (username, email, password_hash, activated)
 	VALUES ($1, $2, $3, $4)
@@ -41,29 +45,32 @@ func (u *UserModel) Insert(user *User) error {
 
 	args := []any{user.Username, user.Email, user.Password.hash, user.Activated}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.QueryTimeout)
 	defer cancel()
 
-	//if an email already exists, we will get a pq error message
-	err := u.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.Created_At, &user.Version)
-	if err != nil {
-		switch {
-		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
-			return ErrDuplicateEmail
-		default:
-			return err
+	return runTx(ctx, u.DB, func(tx *sql.Tx) error {
+		//if an email already exists, we will get a pq error message
+		err := tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.Created_At, &user.Version)
+		if err != nil {
+			switch {
+			case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
+				return ErrDuplicateEmail
+			default:
+				return err
+			}
 		}
-	}
-	return nil
+
+		return setPermissions(ctx, tx, user.ID, permissions)
+	})
 }
 
-func (u *UserModel) GetByEmail(email string) (*User, error) {
+func (u *UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	query := `
 	SELECT id, created_at, username, email, password_hash, activated, version
 	FROM users
 	WHERE email = $1
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.QueryTimeout)
 	defer cancel()
 
 	var user User
@@ -88,13 +95,13 @@ func (u *UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (u *UserModel) GetUserProfile(id int64) (*User, error) {
+func (u *UserModel) GetUserProfile(ctx context.Context, id int64) (*User, error) {
 	query := `
 	SELECT id, created_at, username, email, activated, version
 	FROM users
 	WHERE id = $1
 	`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.QueryTimeout)
 	defer cancel()
 
 	var user User
@@ -118,17 +125,18 @@ func (u *UserModel) GetUserProfile(id int64) (*User, error) {
 	return &user, nil
 }
 
-func (u *UserModel) GetUserLists(id int64) ([]*ReadList, error) {
+// GetUserLists returns the lists of user id that viewer is allowed to see
+func (u *UserModel) GetUserLists(ctx context.Context, id int64, viewer int64) ([]*ReadList, error) {
 
-	query := `
-	SELECT R.id, R.name, R.description, S.name
+	query := fmt.Sprintf(`
+	SELECT R.id, R.name, R.description, S.name, R.visibility, %s, R.version, R.updated_at
 	FROM readlist AS R
 	INNER JOIN status AS S ON R.status = S.id
-	WHERE created_by = $1
-	`
+	WHERE created_by = $1 AND %s
+	`, listShareToken(2), listVisibleTo(2))
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	rows, err := u.DB.QueryContext(ctx, query, id)
+	ctx, cancel := context.WithTimeout(ctx, u.QueryTimeout)
+	rows, err := u.DB.QueryContext(ctx, query, id, viewer)
 
 	readLists := []*ReadList{}
 
@@ -140,11 +148,11 @@ func (u *UserModel) GetUserLists(id int64) ([]*ReadList, error) {
 
 	for rows.Next() {
 		var readList ReadList
-		err := rows.Scan(&readList.ID, &readList.Name, &readList.Description, &readList.Status)
+		err := rows.Scan(&readList.ID, &readList.Name, &readList.Description, &readList.Status, &readList.Visibility, &readList.ShareToken, &readList.Version, &readList.Updated_at)
 		if err != nil {
 			return nil, err
 		}
-		readList.Book, err = u.GetAllById(readList.ID)
+		readList.Book, err = u.GetAllById(ctx, readList.ID)
 		if err != nil {
 			return nil, err
 		}
@@ -160,15 +168,15 @@ func (u *UserModel) GetUserLists(id int64) ([]*ReadList, error) {
 	return readLists, nil
 }
 
-func (u *UserModel) GetUserReviews(id int64) ([]*Review, error) {
+func (u *UserModel) GetUserReviews(ctx context.Context, id int64) ([]*Review, error) {
 	query := `
-	SELECT R.id, B.title, U.username, R.review, R.rating, R.created_at FROM book_reviews AS R
+	SELECT R.id, B.title, U.username, R.review, R.rating, R.created_at, R.updated_at FROM book_reviews AS R
 	INNER JOIN books AS B ON R.book_id = B.id 
 	INNER JOIN users AS U ON R.user_id = U.id
 	WHERE R.user_id = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.QueryTimeout)
 	defer cancel()
 
 	rows, err := u.DB.QueryContext(ctx, query, id)
@@ -182,7 +190,7 @@ func (u *UserModel) GetUserReviews(id int64) ([]*Review, error) {
 
 	for rows.Next() {
 		var review Review
-		err := rows.Scan(&review.ID, &review.Book, &review.User, &review.Review, &review.Rating, &review.Created_at)
+		err := rows.Scan(&review.ID, &review.Book, &review.User, &review.Review, &review.Rating, &review.Created_at, &review.Updated_at)
 		if err != nil {
 			return nil, err
 		}
@@ -198,7 +206,7 @@ func (u *UserModel) GetUserReviews(id int64) ([]*Review, error) {
 	return reviews, nil
 }
 
-func (u *UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error) {
+func (u *UserModel) GetForToken(ctx context.Context, tokenScope, tokenPlainText string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenPlainText))
 
 	query := `
@@ -215,7 +223,7 @@ func (u *UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error
 
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.QueryTimeout)
 	defer cancel()
 	err := u.DB.QueryRowContext(ctx, query, args...).Scan(
 		&user.ID,
@@ -239,7 +247,7 @@ func (u *UserModel) GetForToken(tokenScope, tokenPlainText string) (*User, error
 	return &user, nil
 }
 
-func (u *UserModel) Update(user *User) error {
+func (u *UserModel) Update(ctx context.Context, user *User) error {
 	query := `
 	UPDATE users
 	SET username = $1, email =$2, password_hash = $3, activated = $4,
@@ -250,7 +258,7 @@ func (u *UserModel) Update(user *User) error {
 
 	args := []any{user.Username, user.Email, user.Password.hash, user.Activated, user.ID, user.Version}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.QueryTimeout)
 	defer cancel()
 
 	err := u.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
@@ -326,14 +334,14 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymouseUser
 }
 
-func (u *UserModel) UserExist(id int64) error {
+func (u *UserModel) UserExist(ctx context.Context, id int64) error {
 	query := `
 	SELECT users.id
 	FROM users
 	WHERE id = $1
 	`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, u.QueryTimeout)
 	defer cancel()
 
 	var ID int64
@@ -0,0 +1,27 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("object not found")
+var ErrInvalidKey = errors.New("invalid object key")
+
+// Storage keeps uploaded files under slash separated keys such as
+// "covers/12/1700000000.jpg". The local filesystem is the only backend for
+// now, anything that can put, get and delete by key can stand in for it.
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader) error
+	Get(ctx context.Context, key string) (*Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Object is a stored file opened for reading, the caller closes Body
+type Object struct {
+	Body    io.ReadSeekCloser
+	Size    int64
+	ModTime time.Time
+}
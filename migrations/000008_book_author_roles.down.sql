@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS book_authors_author_id_idx;
+DROP INDEX IF EXISTS book_authors_book_author_role_key;
+ALTER TABLE book_authors DROP CONSTRAINT IF EXISTS book_authors_role_check;
+ALTER TABLE book_authors DROP COLUMN IF EXISTS role;
+DROP INDEX IF EXISTS authors_name_key;
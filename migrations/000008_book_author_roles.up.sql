@@ -0,0 +1,27 @@
+-- fold authors that only differ by case or surrounding spaces into one
+WITH canonical AS (
+    SELECT id, MIN(id) OVER (PARTITION BY lower(btrim(name))) AS keep_id
+    FROM authors
+)
+UPDATE book_authors
+SET author_id = canonical.keep_id
+FROM canonical
+WHERE book_authors.author_id = canonical.id AND canonical.id <> canonical.keep_id;
+
+DELETE FROM authors AS A
+USING authors AS B
+WHERE lower(btrim(A.name)) = lower(btrim(B.name)) AND A.id > B.id;
+
+UPDATE authors SET name = btrim(name);
+
+CREATE UNIQUE INDEX IF NOT EXISTS authors_name_key ON authors (lower(name));
+
+ALTER TABLE book_authors ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'author';
+ALTER TABLE book_authors ADD CONSTRAINT book_authors_role_check CHECK (role IN ('author', 'translator', 'editor'));
+
+DELETE FROM book_authors AS A
+USING book_authors AS B
+WHERE A.book_id = B.book_id AND A.author_id = B.author_id AND A.role = B.role AND A.id > B.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS book_authors_book_author_role_key ON book_authors (book_id, author_id, role);
+CREATE INDEX IF NOT EXISTS book_authors_author_id_idx ON book_authors (author_id);
@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS book_reviews_search_vector_idx;
+ALTER TABLE book_reviews DROP COLUMN IF EXISTS search_vector;
+
+DROP INDEX IF EXISTS books_search_vector_idx;
+ALTER TABLE books DROP COLUMN IF EXISTS search_vector;
+
+DROP TRIGGER IF EXISTS authors_refresh_names ON authors;
+DROP FUNCTION IF EXISTS authors_refresh_names();
+DROP TRIGGER IF EXISTS book_authors_refresh_names ON book_authors;
+DROP FUNCTION IF EXISTS book_authors_refresh_names();
+DROP FUNCTION IF EXISTS refresh_book_author_names(INT);
+
+ALTER TABLE books DROP COLUMN IF EXISTS author_names;
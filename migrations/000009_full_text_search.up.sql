@@ -0,0 +1,61 @@
+-- a generated column can't look at other tables, so the author names are
+-- copied onto the book and kept in step by triggers
+ALTER TABLE books ADD COLUMN IF NOT EXISTS author_names TEXT NOT NULL DEFAULT '';
+
+CREATE OR REPLACE FUNCTION refresh_book_author_names(target_book_id INT) RETURNS VOID AS $$
+    UPDATE books
+    SET author_names = COALESCE((
+        SELECT string_agg(A.name, ' ' ORDER BY A.name)
+        FROM book_authors AS BA
+        INNER JOIN authors AS A ON A.id = BA.author_id
+        WHERE BA.book_id = target_book_id
+    ), '')
+    WHERE id = target_book_id;
+$$ LANGUAGE SQL;
+
+CREATE OR REPLACE FUNCTION book_authors_refresh_names() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM refresh_book_author_names(OLD.book_id);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM refresh_book_author_names(NEW.book_id);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_authors_refresh_names
+AFTER INSERT OR UPDATE OR DELETE ON book_authors
+FOR EACH ROW EXECUTE FUNCTION book_authors_refresh_names();
+
+CREATE OR REPLACE FUNCTION authors_refresh_names() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM refresh_book_author_names(BA.book_id)
+    FROM book_authors AS BA
+    WHERE BA.author_id = NEW.id;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER authors_refresh_names
+AFTER UPDATE OF name ON authors
+FOR EACH ROW EXECUTE FUNCTION authors_refresh_names();
+
+SELECT refresh_book_author_names(id) FROM books;
+
+-- weights rank a title hit above an author, genre and then description hit
+ALTER TABLE books ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('english'::regconfig, COALESCE(title, '')), 'A') ||
+    setweight(to_tsvector('english'::regconfig, author_names), 'B') ||
+    setweight(to_tsvector('english'::regconfig, COALESCE(genre, '')), 'C') ||
+    setweight(to_tsvector('english'::regconfig, COALESCE(description, '')), 'D')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS books_search_vector_idx ON books USING GIN (search_vector);
+
+ALTER TABLE book_reviews ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    to_tsvector('english'::regconfig, review)
+) STORED;
+
+CREATE INDEX IF NOT EXISTS book_reviews_search_vector_idx ON book_reviews USING GIN (search_vector);
@@ -0,0 +1 @@
+-- the original formatting isn't kept so there is nothing to undo
@@ -0,0 +1,41 @@
+-- bring stored ISBNs into the canonical ISBN-13 form the API now writes,
+-- leaving alone anything that isn't a valid ISBN-10 or would collide with a
+-- book that already has the canonical form
+CREATE OR REPLACE FUNCTION canonical_isbn(raw TEXT) RETURNS TEXT AS $$
+DECLARE
+    isbn TEXT := upper(regexp_replace(raw, '[\s-]', '', 'g'));
+    body TEXT;
+    total INT := 0;
+BEGIN
+    IF isbn ~ '^[0-9]{9}[0-9X]$' THEN
+        FOR i IN 1..9 LOOP
+            total := total + substr(isbn, i, 1)::INT * (11 - i);
+        END LOOP;
+        total := total + CASE WHEN right(isbn, 1) = 'X' THEN 10 ELSE right(isbn, 1)::INT END;
+        IF total % 11 <> 0 THEN
+            RETURN isbn;
+        END IF;
+
+        body := '978' || left(isbn, 9);
+        total := 0;
+        FOR i IN 1..12 LOOP
+            total := total + substr(body, i, 1)::INT * CASE WHEN i % 2 = 1 THEN 1 ELSE 3 END;
+        END LOOP;
+        RETURN body || ((10 - total % 10) % 10)::TEXT;
+    END IF;
+
+    RETURN isbn;
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+UPDATE books AS B
+SET isbn = canonical_isbn(B.isbn)
+WHERE B.isbn IS NOT NULL
+AND canonical_isbn(B.isbn) <> B.isbn
+AND NOT EXISTS (
+    SELECT 1 FROM books AS O
+    WHERE O.id <> B.id
+    AND (O.isbn = canonical_isbn(B.isbn) OR (canonical_isbn(O.isbn) = canonical_isbn(B.isbn) AND O.id < B.id))
+);
+
+DROP FUNCTION canonical_isbn(TEXT);
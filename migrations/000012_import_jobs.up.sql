@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) ON DELETE SET NULL,
+    format VARCHAR(10) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    total_rows INT NOT NULL DEFAULT 0,
+    processed INT NOT NULL DEFAULT 0,
+    imported INT NOT NULL DEFAULT 0,
+    duplicates INT NOT NULL DEFAULT 0,
+    failed INT NOT NULL DEFAULT 0,
+    report JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP(0) WITH TIME ZONE
+);
@@ -0,0 +1,3 @@
+ALTER TABLE books DROP COLUMN IF EXISTS cover_updated_at;
+ALTER TABLE books DROP COLUMN IF EXISTS cover_thumbnail_key;
+ALTER TABLE books DROP COLUMN IF EXISTS cover_key;
@@ -0,0 +1,3 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS cover_key TEXT;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS cover_thumbnail_key TEXT;
+ALTER TABLE books ADD COLUMN IF NOT EXISTS cover_updated_at TIMESTAMP(0) WITH TIME ZONE;
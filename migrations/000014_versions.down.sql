@@ -0,0 +1,3 @@
+ALTER TABLE book_reviews DROP COLUMN IF EXISTS version;
+ALTER TABLE readList DROP COLUMN IF EXISTS version;
+ALTER TABLE books DROP COLUMN IF EXISTS version;
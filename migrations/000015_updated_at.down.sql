@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS book_list_touch_list ON book_list;
+DROP FUNCTION IF EXISTS book_list_touch_list();
+
+DROP TRIGGER IF EXISTS book_reviews_set_updated_at ON book_reviews;
+DROP TRIGGER IF EXISTS readlist_set_updated_at ON readList;
+DROP TRIGGER IF EXISTS books_set_updated_at ON books;
+DROP FUNCTION IF EXISTS set_updated_at();
+
+ALTER TABLE book_reviews DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE readList DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE books DROP COLUMN IF EXISTS updated_at;
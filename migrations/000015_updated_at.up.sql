@@ -0,0 +1,37 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW();
+ALTER TABLE readList ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW();
+ALTER TABLE book_reviews ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP(0) WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+-- a trigger rather than every UPDATE remembering to set it, books are also
+-- changed by the rating and author name maintenance
+CREATE OR REPLACE FUNCTION set_updated_at() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = NOW();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER books_set_updated_at BEFORE UPDATE ON books
+FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TRIGGER readlist_set_updated_at BEFORE UPDATE ON readList
+FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+CREATE TRIGGER book_reviews_set_updated_at BEFORE UPDATE ON book_reviews
+FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+-- adding or removing a book changes the list
+CREATE OR REPLACE FUNCTION book_list_touch_list() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE readList SET updated_at = NOW() WHERE id = OLD.list_id;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE readList SET updated_at = NOW() WHERE id = NEW.list_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_list_touch_list AFTER INSERT OR UPDATE OR DELETE ON book_list
+FOR EACH ROW EXECUTE FUNCTION book_list_touch_list();
@@ -0,0 +1,6 @@
+ALTER TABLE book_list DROP CONSTRAINT IF EXISTS book_list_finished_after_started;
+ALTER TABLE book_list DROP COLUMN IF EXISTS finished_at;
+ALTER TABLE book_list DROP COLUMN IF EXISTS started_at;
+ALTER TABLE book_list DROP COLUMN IF EXISTS percent;
+ALTER TABLE book_list DROP COLUMN IF EXISTS current_page;
+ALTER TABLE book_list DROP COLUMN IF EXISTS status;
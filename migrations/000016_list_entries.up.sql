@@ -0,0 +1,15 @@
+ALTER TABLE book_list ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'want-to-read'
+    CHECK (status IN ('want-to-read', 'reading', 'finished', 'abandoned'));
+ALTER TABLE book_list ADD COLUMN IF NOT EXISTS current_page INT CHECK (current_page >= 0);
+ALTER TABLE book_list ADD COLUMN IF NOT EXISTS percent NUMERIC(5, 2) CHECK (percent BETWEEN 0 AND 100);
+ALTER TABLE book_list ADD COLUMN IF NOT EXISTS started_at TIMESTAMP(0) WITH TIME ZONE;
+ALTER TABLE book_list ADD COLUMN IF NOT EXISTS finished_at TIMESTAMP(0) WITH TIME ZONE;
+ALTER TABLE book_list ADD CONSTRAINT book_list_finished_after_started CHECK (finished_at >= started_at);
+
+-- entries start out with the status their list had
+UPDATE book_list AS BL
+SET status = CASE S.name WHEN 'Completed' THEN 'finished' ELSE 'reading' END
+FROM readList AS R
+INNER JOIN status AS S
+ON R.status = S.id
+WHERE R.id = BL.list_id;
@@ -0,0 +1,3 @@
+ALTER TABLE book_list DROP CONSTRAINT IF EXISTS book_list_list_id_position_key;
+ALTER TABLE book_list DROP CONSTRAINT IF EXISTS book_list_list_id_book_id_key;
+ALTER TABLE book_list DROP COLUMN IF EXISTS position;
@@ -0,0 +1,22 @@
+-- a book is only on a list once, keep the entry added first
+DELETE FROM book_list AS A
+USING book_list AS B
+WHERE A.list_id = B.list_id AND A.book_id = B.book_id AND A.id > B.id;
+
+ALTER TABLE book_list ADD COLUMN IF NOT EXISTS position INT;
+
+UPDATE book_list AS BL
+SET position = P.position
+FROM (
+    SELECT id, row_number() OVER (PARTITION BY list_id ORDER BY id) AS position
+    FROM book_list
+) AS P
+WHERE P.id = BL.id;
+
+ALTER TABLE book_list ALTER COLUMN position SET NOT NULL;
+
+ALTER TABLE book_list ADD CONSTRAINT book_list_list_id_book_id_key UNIQUE (list_id, book_id);
+
+-- deferred so a reorder can shuffle positions within one transaction
+ALTER TABLE book_list ADD CONSTRAINT book_list_list_id_position_key UNIQUE (list_id, position)
+    DEFERRABLE INITIALLY DEFERRED;
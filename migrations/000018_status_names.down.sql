@@ -0,0 +1 @@
+DROP INDEX IF EXISTS status_name_key;
@@ -0,0 +1 @@
+CREATE UNIQUE INDEX IF NOT EXISTS status_name_key ON status (lower(name));
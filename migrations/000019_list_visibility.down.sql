@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS readlist_share_token_key;
+ALTER TABLE readList DROP COLUMN IF EXISTS share_token;
+ALTER TABLE readList DROP COLUMN IF EXISTS visibility;
@@ -0,0 +1,6 @@
+-- lists were all visible to everyone until now, so they start out public
+ALTER TABLE readList ADD COLUMN IF NOT EXISTS visibility VARCHAR(10) NOT NULL DEFAULT 'public'
+    CHECK (visibility IN ('public', 'private', 'unlisted'));
+ALTER TABLE readList ADD COLUMN IF NOT EXISTS share_token TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS readlist_share_token_key ON readList (share_token);